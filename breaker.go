@@ -0,0 +1,107 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by Lock while the circuit breaker of the mutex
+// is open after repeated Redis failures.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// A BreakerState is the state of the circuit breaker of a mutex.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every Lock through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails every Lock right away until the cooldown passed.
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial Lock through after the cooldown.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// breaker opens after a number of consecutive Redis failures of Lock.
+type breaker struct {
+	failures int
+	cooldown time.Duration
+
+	mu          sync.Mutex
+	state       BreakerState
+	consecutive int
+	openedAt    time.Time
+}
+
+// allow reports whether a Lock may proceed, moving an open breaker whose
+// cooldown passed to half-open for a single trial.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// The trial is still in flight.
+		return false
+	}
+	return true
+}
+
+// record updates the breaker with the result of a Lock.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !isRedisFailure(err) {
+		b.consecutive = 0
+		b.state = BreakerClosed
+		return
+	}
+	b.consecutive++
+	if b.state == BreakerHalfOpen || b.consecutive >= b.failures {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// isRedisFailure reports whether a Lock error stems from talking to Redis,
+// as opposed to the lock being busy or the caller giving up.
+func isRedisFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, target := range []error{ErrLockTimeout, ErrRetryAborted, ErrDraining, ErrSelfLock, ErrTooManyWaiters, context.Canceled, context.DeadlineExceeded} {
+		if errors.Is(err, target) {
+			return false
+		}
+	}
+	return true
+}
+
+// BreakerState returns the state of the circuit breaker of the mutex, for
+// metrics. It is always BreakerClosed without WithCircuitBreaker.
+func (dl *Mutex) BreakerState() BreakerState {
+	if dl.breaker == nil {
+		return BreakerClosed
+	}
+	dl.breaker.mu.Lock()
+	defer dl.breaker.mu.Unlock()
+	return dl.breaker.state
+}
@@ -0,0 +1,41 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRetryAborted is returned by Lock when the retry decider stopped the
+// wait for the lock.
+var ErrRetryAborted = errors.New("lock retry aborted")
+
+// waitState tracks a wait for the lock across the retries of the blocking
+// flow, which restarts after every unlock notification.
+type waitState struct {
+	start time.Time
+	tries atomic.Int64
+}
+
+type waitStateKey struct{}
+
+// waitStateFor returns the wait state carried by ctx, or starts a new one
+// and returns a context carrying it if the wait just began.
+func waitStateFor(ctx context.Context) (context.Context, *waitState) {
+	if ws, ok := ctx.Value(waitStateKey{}).(*waitState); ok {
+		return ctx, ws
+	}
+	ws := &waitState{start: time.Now()}
+	return context.WithValue(ctx, waitStateKey{}, ws), ws
+}
+
+// decideRetry reports whether the retry decider, if any, lets the wait
+// make another attempt.
+func (dl *Mutex) decideRetry(ctx context.Context, ws *waitState) bool {
+	if dl.retryDecider == nil {
+		return true
+	}
+	try := int(ws.tries.Add(1))
+	return dl.retryDecider(ctx, try, time.Since(ws.start))
+}
@@ -0,0 +1,130 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	epochKey = "distributed_lock_epoch"
+	// epochPrefix marks the value of a lock taken by a mutex created with
+	// WithEpoch, followed by the epoch and a colon.
+	epochPrefix = "epoch:"
+)
+
+// ErrStaleEpoch is returned by Extend and Valid of a mutex created with
+// WithEpoch when the epoch was bumped since the lock was acquired.
+var ErrStaleEpoch = errors.New("lock epoch is stale")
+
+// BumpEpoch increments the epoch, invalidating all locks held by mutexes
+// created with WithEpoch, e.g. on a configuration rollover. The locks stay
+// in Redis, but their Extend and Valid fail with ErrStaleEpoch, so their
+// renewal watchdogs report them lost and stop renewing, and they expire
+// within the mutex expiry unless unlocked first. Cooperating holders are
+// expected to stop working under the lock on that error. Locks acquired
+// after BumpEpoch carry the new epoch.
+func (r *PSLock) BumpEpoch(ctx context.Context) error {
+	for _, c := range r.clients() {
+		if err := c.Incr(ctx, epochKey).Err(); err != nil {
+			return fmt.Errorf("failed to bump lock epoch: %w", err)
+		}
+	}
+	return nil
+}
+
+// currentEpoch returns the epoch on the mutex client, "0" until the first
+// BumpEpoch.
+func (dl *Mutex) currentEpoch(ctx context.Context) (string, error) {
+	epoch, err := withOpTimeout(ctx, dl, func(ctx context.Context) (string, error) {
+		return dl.client.Get(ctx, epochKey).Result()
+	})
+	if err == redis.Nil {
+		return "0", nil
+	}
+	return epoch, err
+}
+
+// epochValue marks value with epoch.
+func epochValue(epoch, value string) string {
+	return epochPrefix + epoch + ":" + value
+}
+
+// trimEpoch returns value without the mark of WithEpoch.
+func trimEpoch(value string) string {
+	rest, ok := strings.CutPrefix(value, epochPrefix)
+	if !ok {
+		return value
+	}
+	if _, after, ok := strings.Cut(rest, ":"); ok {
+		return after
+	}
+	return value
+}
+
+// checkEpoch returns ErrStaleEpoch if the epoch of the current hold is no
+// longer the current epoch. The check is not atomic with the operation it
+// guards, so an operation racing with BumpEpoch may still succeed once.
+func (dl *Mutex) checkEpoch(ctx context.Context) error {
+	if !dl.epoch {
+		return nil
+	}
+	epoch, err := dl.currentEpoch(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get epoch of lock %q: %w", dl.key, err)
+	}
+	dl.mu.Lock()
+	held := dl.heldEpoch
+	dl.mu.Unlock()
+	if epoch != held {
+		return fmt.Errorf("%w: %q acquired in epoch %s, now %s", ErrStaleEpoch, dl.key, held, epoch)
+	}
+	return nil
+}
+
+// Valid reports whether the mutex still holds its lock in Redis and, with
+// WithEpoch, in the current epoch. A stale epoch is reported as false
+// with ErrStaleEpoch. A hold past Until is reported as false without
+// asking Redis, so that WithClockSkew makes it unsafe early.
+func (dl *Mutex) Valid(ctx context.Context) (bool, error) {
+	if ctx == nil {
+		return false, fmt.Errorf("%w: %q", ErrNilContext, dl.key)
+	}
+	dl.mu.Lock()
+	value, previous := dl.tokensFor(dl.value)
+	dl.mu.Unlock()
+	if value == "" {
+		return false, nil
+	}
+	// Holds of AddToPipe have no local estimate.
+	if until := dl.Until(); !until.IsZero() && !time.Now().Before(until) {
+		return false, nil
+	}
+	if err := dl.checkEpoch(ctx); err != nil {
+		return false, err
+	}
+	held, err := withOpTimeout(ctx, dl, func(ctx context.Context) (string, error) {
+		return dl.client.Get(ctx, dl.getKey()).Result()
+	})
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check lock %q: %w", dl.key, err)
+	}
+	return held == value || (previous != "" && held == previous), nil
+}
+
+// WithEpoch can be used to scope the locks of the mutex to the epoch
+// current at acquisition, so that PSLock.BumpEpoch invalidates them all
+// at once. The epoch is embedded in the lock value and costs a round trip
+// per acquisition and extension. The default ignores epochs.
+func WithEpoch() Option {
+	return OptionFunc(func(m *Mutex) {
+		m.epoch = true
+	})
+}
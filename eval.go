@@ -0,0 +1,28 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+)
+
+// Eval runs a Lua script atomically against the lock, for custom operations
+// such as a conditional extend that updates other keys too. The script is
+// called with KEYS[1] set to the Redis key of the lock and ARGV[1] set to
+// the value written by the current hold, which is empty if the mutex does
+// not hold the lock; args are passed as ARGV[2] onwards. The script should
+// check GET KEYS[1] == ARGV[1] before touching the lock, since the hold may
+// have expired. The reply is returned as decoded by the Redis client.
+func (dl *Mutex) Eval(ctx context.Context, script string, args ...any) (any, error) {
+	dl.mu.Lock()
+	value := dl.value
+	dl.mu.Unlock()
+
+	argv := append([]any{value}, args...)
+	res, err := withOpTimeout(ctx, dl, func(ctx context.Context) (any, error) {
+		return dl.client.Eval(ctx, script, []string{dl.getKey()}, argv...).Result()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to eval script on lock %q: %w", dl.key, err)
+	}
+	return res, nil
+}
@@ -0,0 +1,118 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	unlockPayload   = "unlock"
+	acquiredPayload = "acquired:"
+)
+
+// An EventType names what happened to a lock.
+type EventType string
+
+const (
+	// EventAcquired is sent when a lock is taken after waiting for it,
+	// and emitted to an EventSink on every acquisition.
+	EventAcquired EventType = "acquired"
+	// EventReleased is sent when a lock is unlocked.
+	EventReleased EventType = "released"
+	// EventExpired is sent by MonitorAll when a lock key expires in Redis.
+	EventExpired EventType = "expired"
+	// EventExtended is emitted to an EventSink when a lock is extended.
+	EventExtended EventType = "extended"
+	// EventLost is emitted to an EventSink when a holder detects that
+	// its lock was lost.
+	EventLost EventType = "lost"
+	// EventStolen is emitted to an EventSink when StealLock took a lock
+	// from another holder, after the acquired event of the thief.
+	EventStolen EventType = "stolen"
+	// EventForceUnlocked is emitted to the event sinks of a PSLock when
+	// ForceUnlock deleted a lock.
+	EventForceUnlocked EventType = "force_unlocked"
+)
+
+// LockEvent describes a change of a lock observed on its channel or
+// recorded in its history.
+type LockEvent struct {
+	Key   string
+	Event EventType
+	// Holder is the name of the mutex that acquired the lock. It is empty
+	// for release events delivered by Watch.
+	Holder string
+	// Time is when the event was recorded. It is only set by History and
+	// for an EventSink.
+	Time time.Time
+	// Token is the lock value of the hold, including the context metadata
+	// of WithContextMetadata. It is only set for an EventSink.
+	Token string
+}
+
+func isAcquiredPayload(payload string) bool {
+	return strings.HasPrefix(payload, acquiredPayload)
+}
+
+// publishAcquired publishes an acquired event if enabled. A failure is
+// only logged since the lock itself was obtained.
+func (dl *Mutex) publishAcquired(ctx context.Context) {
+	if !dl.acquireEvents {
+		return
+	}
+	if err := dl.getNotifier().Publish(ctx, dl.getKey(), acquiredPayload+dl.name); err != nil {
+		dl.logger.Printf("pslock: failed to publish acquired message for lock %q: %v", dl.key, err)
+	}
+}
+
+// Watch subscribes to the channel of the lock with given key and delivers
+// its release events, and acquired events of mutexes created with
+// WithAcquireEvents. Unrecognized messages are dropped. The channel is
+// closed when ctx is done or the PSLock is closed. Pass the options of the
+// mutexes, such as WithKeyEncoding, to watch the channel they use.
+func (r *PSLock) Watch(ctx context.Context, key string, options ...Option) (<-chan LockEvent, error) {
+	client, encoded := r.locate(key, options)
+	sub := client.Subscribe(ctx, lockPrefix+encoded)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, fmt.Errorf("failed to watch lock %q: %w", key, err)
+	}
+
+	events := make(chan LockEvent)
+	go func() {
+		defer close(events)
+		defer sub.Close()
+
+		msgCh := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.closed:
+				return
+			case msg, ok := <-msgCh:
+				if !ok {
+					return
+				}
+				event := LockEvent{Key: key}
+				switch {
+				case msg.Payload == unlockPayload:
+					event.Event = EventReleased
+				case isAcquiredPayload(msg.Payload):
+					event.Event = EventAcquired
+					event.Holder = strings.TrimPrefix(msg.Payload, acquiredPayload)
+				default:
+					continue
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,71 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNotificationsDisabled is returned by OnExpired when the Redis server
+// does not publish expired events.
+var ErrNotificationsDisabled = errors.New("keyspace notifications for expired events are disabled")
+
+// OnExpired calls fn whenever the lock with given key expires in Redis, as
+// opposed to being unlocked, until ctx is done or the PSLock is closed.
+// The lock is that of mutexes created with options.
+//
+// It relies on keyspace notifications, which Redis disables by default:
+// the server needs notify-keyspace-events to include "E" and "x" (or "A"),
+// e.g. CONFIG SET notify-keyspace-events Ex. OnExpired returns
+// ErrNotificationsDisabled if the server reports that they are off. Where
+// CONFIG is not available, as on some managed services, the setting cannot
+// be checked and fn is simply never called if notifications are off. Redis
+// delivers expired events when it evicts the key, which may be slightly
+// after the TTL ran out.
+func (r *PSLock) OnExpired(ctx context.Context, key string, fn func(), options ...Option) error {
+	client, encoded := r.locate(key, options)
+	if cfg, err := client.ConfigGet(ctx, "notify-keyspace-events").Result(); err == nil {
+		flags := cfg["notify-keyspace-events"]
+		if !strings.Contains(flags, "E") || !strings.ContainsAny(flags, "xA") {
+			return fmt.Errorf("%w: notify-keyspace-events is %q", ErrNotificationsDisabled, flags)
+		}
+	}
+
+	sub := client.Subscribe(ctx, expiredChannel(client))
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return fmt.Errorf("failed to watch expiry of lock %q: %w", key, err)
+	}
+
+	lockKey := lockPrefix + encoded
+	go func() {
+		defer sub.Close()
+
+		msgCh := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.closed:
+				return
+			case msg, ok := <-msgCh:
+				if !ok {
+					return
+				}
+				if msg.Payload == lockKey {
+					fn()
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// expiredChannel returns the channel on which c publishes the expired
+// events of its database.
+func expiredChannel(c *redis.Client) string {
+	return fmt.Sprintf("__keyevent@%d__:expired", c.Options().DB)
+}
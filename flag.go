@@ -0,0 +1,48 @@
+package pslock
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// enabledKeyCacheTTL is how long the state of a WithEnabledKey flag is
+// cached before it is read from Redis again.
+const enabledKeyCacheTTL = time.Second
+
+// flagCacheKey identifies a WithEnabledKey flag on one client, as the
+// instances of NewSpread each hold the flag of their own.
+type flagCacheKey struct {
+	client *redis.Client
+	key    string
+}
+
+type flagState struct {
+	enabled bool
+	read    time.Time
+}
+
+// lockingEnabled reports whether the flag at flagKey on c enables locking,
+// i.e. unless it holds "0" or "false". Reads are cached for
+// enabledKeyCacheTTL across the mutexes of the PSLock using c. If the
+// flag cannot be read, locking stays enabled.
+func (r *PSLock) lockingEnabled(ctx context.Context, c *redis.Client, flagKey string) bool {
+	cacheKey := flagCacheKey{client: c, key: flagKey}
+	r.mu.Lock()
+	state, ok := r.flags[cacheKey]
+	r.mu.Unlock()
+	if ok && time.Since(state.read) < enabledKeyCacheTTL {
+		return state.enabled
+	}
+
+	value, err := c.Get(ctx, flagKey).Result()
+	if err != nil && err != redis.Nil {
+		return true
+	}
+	state = flagState{enabled: value != "0" && value != "false", read: time.Now()}
+	r.mu.Lock()
+	r.flags[cacheKey] = state
+	r.mu.Unlock()
+	return state.enabled
+}
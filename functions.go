@@ -0,0 +1,89 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// functionLibrary registers the ownership-checked scripts as a Redis
+// Function library. The functions mirror unlockScript, extendScript and
+// ttlScript and must be kept in sync with them.
+const functionLibrary = `#!lua name=pslock
+
+local function unlock(keys, args)
+	local v = redis.call("GET", keys[1])
+	if v == args[1] or (args[2] and args[2] ~= "" and v == args[2]) then
+		return redis.call("DEL", keys[1])
+	end
+	return 0
+end
+
+local function extend(keys, args)
+	local v = redis.call("GET", keys[1])
+	if v == args[1] then
+		return redis.call("PEXPIRE", keys[1], args[2])
+	end
+	if args[3] ~= "" and v == args[3] then
+		redis.call("SET", keys[1], args[1], "PX", args[2])
+		return 1
+	end
+	return 0
+end
+
+local function ttl(keys, args)
+	if redis.call("GET", keys[1]) == args[1] then
+		return redis.call("PTTL", keys[1])
+	end
+	return -2
+end
+
+redis.register_function("pslock_unlock", unlock)
+redis.register_function("pslock_extend", extend)
+redis.register_function("pslock_ttl", ttl)
+`
+
+// functionsLoaded reports whether the function library is available on c,
+// loading it on first use. A server rejecting FUNCTION LOAD, e.g. before
+// Redis 7, is remembered as not supporting functions; other failures are
+// retried on the next call.
+func (r *PSLock) functionsLoaded(ctx context.Context, c *redis.Client) bool {
+	r.mu.Lock()
+	loaded, ok := r.functions[c]
+	r.mu.Unlock()
+	if ok {
+		return loaded
+	}
+
+	err := c.FunctionLoadReplace(ctx, functionLibrary).Err()
+	var redisErr redis.Error
+	if err != nil && !errors.As(err, &redisErr) {
+		return false
+	}
+	if err != nil {
+		defaultLogger.Printf("pslock: Redis functions not available, falling back to EVAL: %v", err)
+	}
+	r.mu.Lock()
+	r.functions[c] = err == nil
+	r.mu.Unlock()
+	return err == nil
+}
+
+// runScript runs script, or the function of the library with the same
+// logic if the mutex uses Redis functions and the server supports them.
+// A library lost on the server, e.g. after a FUNCTION FLUSH or a failover
+// to a fresh replica, is loaded again.
+func (dl *Mutex) runScript(ctx context.Context, script *redis.Script, function string, keys []string, args ...any) *redis.Cmd {
+	if !dl.functions || !dl.pslock.functionsLoaded(ctx, dl.client) {
+		return script.Run(ctx, dl.client, keys, args...)
+	}
+	cmd := dl.client.FCall(ctx, function, keys, args...)
+	if err := cmd.Err(); err != nil && strings.Contains(err.Error(), "Function not found") {
+		if err := dl.client.FunctionLoadReplace(ctx, functionLibrary).Err(); err == nil {
+			cmd = dl.client.FCall(ctx, function, keys, args...)
+		}
+	}
+	return cmd
+}
@@ -0,0 +1,91 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	handoffPrefix = "distributed_lock_handoff:"
+)
+
+// handoffScript pushes a handoff token for one waiter if the waiter counter
+// in KEYS[2] is positive, keeping at most one token per waiter.
+var handoffScript = redis.NewScript(`
+local n = tonumber(redis.call("GET", KEYS[2]) or "0")
+if n > 0 then
+	redis.call("RPUSH", KEYS[1], "1")
+	redis.call("LTRIM", KEYS[1], -n, -1)
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+func handoffKey(key string) string {
+	return handoffPrefix + key
+}
+
+// handoffLock waits for the lock by popping a handoff token pushed by
+// Unlock, so that each unlock wakes up a single waiter. A missing token is
+// covered by retrying after the retry delay, rounded up to the one second
+// resolution of BRPOP. Once less than that is left of the patient, the
+// waiter makes a last attempt without blocking instead.
+func (dl *Mutex) handoffLock(ctx context.Context, l lease, ttl time.Duration) error {
+	defer dl.enterWaiters(ctx)()
+	ctx, ws := waitStateFor(ctx)
+
+	blockCtx, cancel := context.WithTimeout(ctx, dl.patient)
+	defer cancel()
+
+	key := handoffKey(dl.keyEncoding.encode(dl.key))
+	deadline, _ := blockCtx.Deadline()
+	for i := range dl.tries {
+		// BRPOP does not observe the context, so bound it by the deadline.
+		remaining := time.Until(deadline)
+		last := remaining < time.Second
+		if !last {
+			timeout := max(min(dl.retryDelay(i, ttl), remaining), time.Second)
+			stopWait := startPhase(blockCtx, PhaseWait)
+			err := dl.client.BRPop(blockCtx, timeout, key).Err()
+			stopWait()
+			if blockCtx.Err() != nil {
+				return fmt.Errorf("%w: %q", ErrLockTimeout, dl.key)
+			}
+			if err != nil && !errors.Is(err, redis.Nil) {
+				return fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+			}
+		}
+		if !dl.decideRetry(blockCtx, ws) {
+			return fmt.Errorf("%w: %q", ErrRetryAborted, dl.key)
+		}
+
+		var success bool
+		var err error
+		stopFastPath := startPhase(blockCtx, PhaseFastPath)
+		success, ttl, err = dl.tryAcquire(blockCtx, &l)
+		stopFastPath()
+		if err == nil && success {
+			dl.acquired(l)
+			dl.publishAcquired(ctx)
+			return nil
+		}
+		if last {
+			break
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrLockTimeout, dl.key)
+}
+
+// handOff passes the released lock to one waiting handoff waiter. Errors
+// are logged only; waiters fall back to their retry delay.
+func (dl *Mutex) handOff(ctx context.Context) {
+	key := dl.keyEncoding.encode(dl.key)
+	keys := []string{handoffKey(key), waitersKey(key)}
+	if err := handoffScript.Run(ctx, dl.client, keys, dl.auxExpiry(dl.expiry).Milliseconds()).Err(); err != nil {
+		dl.logger.Printf("pslock: failed to hand off lock %q: %v", dl.key, err)
+	}
+}
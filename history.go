@@ -0,0 +1,81 @@
+package pslock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	historyPrefix = "distributed_lock_history:"
+)
+
+func historyKey(key string) string {
+	return historyPrefix + key
+}
+
+// historyEntry is the JSON form of a LockEvent in the history list.
+type historyEntry struct {
+	Event  EventType `json:"event"`
+	Holder string    `json:"holder"`
+	// Milliseconds since the Unix epoch
+	Time int64 `json:"time"`
+}
+
+// recordHistory prepends an event to the history list of the lock and
+// trims it to the configured length, refreshing its TTL if WithAuxTTL is
+// given. It is bounded by the op timeout. Errors are logged only.
+func (dl *Mutex) recordHistory(ctx context.Context, event EventType) {
+	entry, err := json.Marshal(historyEntry{Event: event, Holder: dl.name, Time: time.Now().UnixMilli()})
+	if err != nil {
+		dl.logger.Printf("pslock: failed to record history of lock %q: %v", dl.key, err)
+		return
+	}
+	key := historyKey(dl.keyEncoding.encode(dl.key))
+	err = doWithOpTimeout(ctx, dl, func(ctx context.Context) error {
+		_, err := dl.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LPush(ctx, key, entry)
+			pipe.LTrim(ctx, key, 0, int64(dl.historyLen-1))
+			if dl.auxTTL > 0 {
+				pipe.PExpire(ctx, key, dl.auxTTL)
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		dl.logger.Printf("pslock: failed to record history of lock %q: %v", dl.key, err)
+	}
+}
+
+// History returns up to n of the most recent acquisitions and releases of
+// the lock with given key, newest first, as recorded by mutexes created
+// with WithHistory and options. It returns no events for n <= 0.
+func (r *PSLock) History(ctx context.Context, key string, n int, options ...Option) ([]LockEvent, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	client, encoded := r.locate(key, options)
+	entries, err := client.LRange(ctx, historyKey(encoded), 0, int64(n-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history of lock %q: %w", key, err)
+	}
+
+	events := make([]LockEvent, 0, len(entries))
+	for _, e := range entries {
+		var entry historyEntry
+		if err := json.Unmarshal([]byte(e), &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode history of lock %q: %w", key, err)
+		}
+		events = append(events, LockEvent{
+			Key:    key,
+			Event:  entry.Event,
+			Holder: entry.Holder,
+			Time:   time.UnixMilli(entry.Time),
+		})
+	}
+	return events, nil
+}
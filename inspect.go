@@ -0,0 +1,57 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// InspectMany returns the state of the locks with given keys, e.g. for a
+// dashboard, with one pipelined round trip of GET and PTTL per client.
+// Every key is in the result; locks that are not held have Held false.
+// The value and the TTL of a lock are read together but not atomically,
+// so a lock released in between is reported as not held. All keys are
+// looked up for mutexes created with options, e.g. WithKeyEncoding.
+func (r *PSLock) InspectMany(ctx context.Context, keys []string, options ...Option) (map[string]LockInfo, error) {
+	type inspection struct {
+		key string
+		get *redis.StringCmd
+		ttl *redis.DurationCmd
+	}
+	byClient := make(map[*redis.Client][]string)
+	encoded := make(map[string]string, len(keys))
+	for _, key := range keys {
+		c, enc := r.locate(key, options)
+		byClient[c] = append(byClient[c], key)
+		encoded[key] = enc
+	}
+
+	infos := make(map[string]LockInfo, len(keys))
+	for c, keys := range byClient {
+		inspections := make([]inspection, len(keys))
+		_, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				inspections[i] = inspection{
+					key: key,
+					get: pipe.Get(ctx, lockPrefix+encoded[key]),
+					ttl: pipe.PTTL(ctx, lockPrefix+encoded[key]),
+				}
+			}
+			return nil
+		})
+		if err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("failed to inspect locks: %w", err)
+		}
+
+		for _, in := range inspections {
+			info := LockInfo{Key: in.key}
+			// PTTL replies -2 for a missing key and -1 without expiry.
+			if value, err := in.get.Result(); err == nil && in.ttl.Val() != -2 {
+				info.Held, info.Token, info.TTL = true, value, in.ttl.Val()
+			}
+			infos[in.key] = info
+		}
+	}
+	return infos, nil
+}
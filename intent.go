@@ -0,0 +1,159 @@
+package pslock
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	intentPrefix = "distributed_lock_intent:"
+)
+
+// acquireChildScript locks a child unless its parent is write-locked, and
+// records an intent marker for the child on the parent. Markers are
+// scored by their deadline in milliseconds of Redis server time. With
+// ARGV[3] set to "1" it resumes a child already held with the same value
+// like acquireScript.
+var acquireChildScript = redis.NewScript(`
+local resumed = ARGV[3] == "1" and redis.call("GET", KEYS[1]) == ARGV[1]
+if resumed then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+else
+	if redis.call("EXISTS", KEYS[2]) == 1 then
+		return {0, redis.call("PTTL", KEYS[2])}
+	end
+	if not redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+		return {0, redis.call("PTTL", KEYS[1])}
+	end
+end
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("ZADD", KEYS[3], now + tonumber(ARGV[2]), ARGV[1])
+if redis.call("PTTL", KEYS[3]) < tonumber(ARGV[2]) then
+	redis.call("PEXPIRE", KEYS[3], ARGV[2])
+end
+if resumed then
+	return {2, 0}
+end
+return {1, 0}
+`)
+
+// acquireParentScript write-locks a parent only if no child holds a live
+// intent marker on it, and resumes like acquireChildScript.
+var acquireParentScript = redis.NewScript(`
+if ARGV[3] == "1" and redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return {2, 0}
+end
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", now)
+if redis.call("ZCARD", KEYS[2]) > 0 then
+	return {0, 0}
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return {1, 0}
+end
+return {0, redis.call("PTTL", KEYS[1])}
+`)
+
+// refreshIntentScript moves the deadline of the intent marker ARGV[1] to
+// ARGV[2] milliseconds from now if the marker is still there, and keeps
+// the marker set alive as long. A marker already cleared by a parent
+// writer is not put back.
+var refreshIntentScript = redis.NewScript(`
+if not redis.call("ZSCORE", KEYS[1], ARGV[1]) then
+	return 0
+end
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("ZADD", KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+if redis.call("PTTL", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+func intentKey(parent string) string {
+	return intentPrefix + parent
+}
+
+// usesIntents reports whether the mutex takes part in intent locking.
+func (dl *Mutex) usesIntents() bool {
+	return dl.intentParent != "" || dl.intentCheck
+}
+
+// tryAcquireIntent runs the intent-aware acquisition for a child or a
+// parent writer and returns the {acquired, PTTL} reply. resume is passed
+// on as in acquireScript.
+func (dl *Mutex) tryAcquireIntent(ctx context.Context, c redis.Cmdable, l lease, resume string) ([]int64, error) {
+	if dl.intentParent != "" {
+		parent := dl.keyEncoding.encode(dl.intentParent)
+		keys := []string{dl.getKey(), lockPrefix + parent, intentKey(parent)}
+		return acquireChildScript.Run(ctx, c, keys, l.value, l.expiry.Milliseconds(), resume).Int64Slice()
+	}
+	keys := []string{dl.getKey(), intentKey(dl.keyEncoding.encode(dl.key))}
+	return acquireParentScript.Run(ctx, c, keys, l.value, l.expiry.Milliseconds(), resume).Int64Slice()
+}
+
+// releaseIntent removes the intent marker of a released child and wakes
+// up parent writers waiting for the intents to clear. Errors are logged
+// only; a leftover marker expires with the child lease.
+func (dl *Mutex) releaseIntent(ctx context.Context, value string) {
+	if dl.intentParent == "" {
+		return
+	}
+	parent := dl.keyEncoding.encode(dl.intentParent)
+	if err := dl.client.ZRem(ctx, intentKey(parent), value).Err(); err != nil {
+		dl.logger.Printf("pslock: failed to remove intent of lock %q on %q: %v", dl.key, dl.intentParent, err)
+		return
+	}
+	if err := dl.getNotifier().Publish(ctx, lockPrefix+parent, unlockPayload); err != nil {
+		dl.logger.Printf("pslock: failed to publish intent release of lock %q on %q: %v", dl.key, dl.intentParent, err)
+	}
+}
+
+// refreshIntent moves the deadline of the intent marker of a child to the
+// expiry its lease was extended to. Errors are logged only; the marker
+// then lapses with the original lease.
+func (dl *Mutex) refreshIntent(ctx context.Context, value string, expiry time.Duration) {
+	if dl.intentParent == "" {
+		return
+	}
+	key := intentKey(dl.keyEncoding.encode(dl.intentParent))
+	err := doWithOpTimeout(ctx, dl, func(ctx context.Context) error {
+		return refreshIntentScript.Run(ctx, dl.client, []string{key}, value, expiry.Milliseconds()).Err()
+	})
+	if err != nil {
+		dl.logger.Printf("pslock: failed to refresh intent of lock %q on %q: %v", dl.key, dl.intentParent, err)
+	}
+}
+
+// WithIntentParent can be used to lock the mutex as a child of parent.
+// Acquiring it fails while parent is write-locked by a mutex with
+// WithIntentCheck, and otherwise places a short-lived intent marker on
+// parent that lives as long as the child lease; Extend, RenewOrReacquire
+// and the watchdog of WithAutoRenew renew it along with the lease. With
+// NewSpread the child is placed on the instance of its parent.
+//
+// This is a lightweight form of multi-granularity locking with limits:
+// the checks are atomic only on a single Redis node (in a cluster the
+// parent and child keys must share a hash tag), markers of crashed
+// children linger until their lease expires, and a steady stream of
+// children can starve a parent writer.
+func WithIntentParent(parent string) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.intentParent = parent
+	})
+}
+
+// WithIntentCheck can be used on a parent writer so that its acquisition
+// waits while any child locked with WithIntentParent holds an intent on
+// the mutex key.
+func WithIntentCheck() Option {
+	return OptionFunc(func(m *Mutex) {
+		m.intentCheck = true
+	})
+}
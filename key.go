@@ -0,0 +1,65 @@
+package pslock
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+const keySeparator = ":"
+
+// keyEscaper escapes separators in key parts, so that different part
+// lists never produce the same key.
+var keyEscaper = strings.NewReplacer(`\`, `\\`, keySeparator, `\`+keySeparator)
+
+// KeyBuilder builds canonical lock keys from parts, e.g.
+// NewKey("user", id, "profile") for "user:<id>:profile". Separators inside
+// a part are escaped. A KeyBuilder is immutable; Add returns a new one.
+type KeyBuilder struct {
+	parts []string
+}
+
+// NewKey returns a KeyBuilder with the given parts.
+func NewKey(parts ...string) KeyBuilder {
+	return KeyBuilder{parts: append([]string(nil), parts...)}
+}
+
+// Add returns a KeyBuilder with parts appended.
+func (k KeyBuilder) Add(parts ...string) KeyBuilder {
+	return NewKey(append(append([]string(nil), k.parts...), parts...)...)
+}
+
+// String returns the key. The lock prefix is added by the mutex.
+func (k KeyBuilder) String() string {
+	escaped := make([]string, len(k.parts))
+	for i, part := range k.parts {
+		escaped[i] = keyEscaper.Replace(part)
+	}
+	return strings.Join(escaped, keySeparator)
+}
+
+// NewMutexFromKey returns a new distributed mutex with the key built by k.
+func (r *PSLock) NewMutexFromKey(k KeyBuilder, options ...Option) *Mutex {
+	return r.NewMutex(k.String(), options...)
+}
+
+// A KeyEncoding maps a lock key to the string used for it in Redis keys and
+// channels. It must be injective so that distinct keys never collide.
+type KeyEncoding func(key string) string
+
+func (e KeyEncoding) encode(key string) string {
+	if e == nil {
+		return key
+	}
+	return e(key)
+}
+
+// HexKeyEncoding encodes the key as lowercase hex.
+func HexKeyEncoding(key string) string {
+	return hex.EncodeToString([]byte(key))
+}
+
+// Base64KeyEncoding encodes the key as unpadded URL-safe base64.
+func Base64KeyEncoding(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
@@ -0,0 +1,84 @@
+package pslock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeyBuilder(t *testing.T) {
+	tests := []struct {
+		key  KeyBuilder
+		want string
+	}{
+		{NewKey("user", "42", "profile"), "user:42:profile"},
+		{NewKey("user").Add("42").Add("profile"), "user:42:profile"},
+		{NewKey("user", "a:b"), `user:a\:b`},
+		{NewKey("user", `a\`, "b"), `user:a\\:b`},
+		{NewKey(), ""},
+	}
+	for _, tt := range tests {
+		if got := tt.key.String(); got != tt.want {
+			t.Errorf("expected key %q, got %q", tt.want, got)
+		}
+	}
+
+	// Parts containing separators do not collide with split parts.
+	if NewKey("a:b").String() == NewKey("a", "b").String() {
+		t.Error("expected escaped part not to collide with split parts")
+	}
+
+	base := NewKey("user")
+	base.Add("1")
+	if got := base.String(); got != "user" {
+		t.Errorf("expected Add not to modify the builder, got %q", got)
+	}
+}
+
+func TestPSLock_NewMutexFromKey(t *testing.T) {
+	r := New(mockRedisClient())
+	mutex := r.NewMutexFromKey(NewKey("user", "42", "profile"))
+	if mutex.getKey() != lockPrefix+"user:42:profile" {
+		t.Errorf("unexpected lock key %q", mutex.getKey())
+	}
+}
+
+func TestMutex_KeyEncoding(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+
+	for _, enc := range []KeyEncoding{HexKeyEncoding, Base64KeyEncoding} {
+		for _, key := range []string{"test-key-encoding-*", "test key encoding", "test-key-encoding-ключ-🔒"} {
+			holder := r.NewMutex(key, WithKeyEncoding(enc))
+			if err := holder.Lock(ctx); err != nil {
+				t.Fatalf("lock %q failed: %v", key, err)
+			}
+			if n, _ := client.Exists(ctx, lockPrefix+enc(key)).Result(); n != 1 {
+				t.Errorf("expected encoded lock key for %q", key)
+			}
+
+			// A waiter is woken up on the encoded channel.
+			waiter := r.NewMutex(key, WithKeyEncoding(enc), WithRetryDelay(time.Second))
+			done := make(chan error, 1)
+			go func() {
+				done <- waiter.Lock(ctx)
+			}()
+			time.Sleep(50 * time.Millisecond)
+			holder.Unlock(ctx)
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("waiter on %q failed: %v", key, err)
+				}
+			case <-time.After(500 * time.Millisecond):
+				t.Fatalf("expected waiter on %q to be notified", key)
+			}
+			waiter.Unlock(ctx)
+		}
+	}
+
+	if HexKeyEncoding("a*") == HexKeyEncoding("a?") {
+		t.Error("expected distinct keys to be encoded distinctly")
+	}
+}
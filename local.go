@@ -0,0 +1,87 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+)
+
+// localLock serializes the goroutines of this process on one key.
+type localLock struct {
+	ch chan struct{}
+	// The number of LocalMutex acquisitions holding or waiting on ch
+	refs int
+}
+
+// LocalMutex is a distributed mutex that serializes goroutines of the same
+// process locally first. Only the local winner goes to Redis, so in-process
+// contention on a key does not generate Redis traffic.
+type LocalMutex struct {
+	pslock *PSLock
+	mutex  *Mutex
+	entry  *localLock
+}
+
+// NewLocalMutex returns a new LocalMutex with given key. The options apply
+// to the underlying distributed mutex.
+func (r *PSLock) NewLocalMutex(key string, options ...Option) *LocalMutex {
+	return &LocalMutex{
+		pslock: r,
+		mutex:  r.NewMutex(key, options...),
+	}
+}
+
+// Lock acquires the local lock for the key, then the distributed lock.
+func (lm *LocalMutex) Lock(ctx context.Context) error {
+	key := lm.mutex.key
+	e := lm.pslock.acquireLocal(key)
+	select {
+	case e.ch <- struct{}{}:
+	case <-ctx.Done():
+		lm.pslock.releaseLocal(key, e)
+		return fmt.Errorf("failed to acquire lock %q: %w", key, ctx.Err())
+	}
+
+	if err := lm.mutex.Lock(ctx); err != nil {
+		<-e.ch
+		lm.pslock.releaseLocal(key, e)
+		return err
+	}
+	lm.entry = e
+	return nil
+}
+
+// Unlock releases the distributed lock, then hands the key to the next
+// local waiter.
+func (lm *LocalMutex) Unlock(ctx context.Context) error {
+	err := lm.mutex.Unlock(ctx)
+	if e := lm.entry; e != nil {
+		lm.entry = nil
+		<-e.ch
+		lm.pslock.releaseLocal(lm.mutex.key, e)
+	}
+	return err
+}
+
+// acquireLocal returns the local lock for key, creating it if needed.
+func (r *PSLock) acquireLocal(key string) *localLock {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.local[key]
+	if !ok {
+		e = &localLock{ch: make(chan struct{}, 1)}
+		r.local[key] = e
+	}
+	e.refs++
+	return e
+}
+
+// releaseLocal drops a reference to the local lock for key and removes it
+// once nobody holds or waits for it.
+func (r *PSLock) releaseLocal(key string, e *localLock) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.refs--
+	if e.refs == 0 {
+		delete(r.local, key)
+	}
+}
@@ -0,0 +1,112 @@
+package pslock
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocalMutex(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-local-mutex"
+
+	var mu sync.Mutex
+	var inside, maxInside int
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lm := r.NewLocalMutex(name)
+			if err := lm.Lock(ctx); err != nil {
+				t.Errorf("lock failed: %v", err)
+				return
+			}
+			mu.Lock()
+			inside++
+			maxInside = max(maxInside, inside)
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			inside--
+			mu.Unlock()
+			if err := lm.Unlock(ctx); err != nil {
+				t.Errorf("unlock failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxInside != 1 {
+		t.Errorf("expected one holder at a time, got %d", maxInside)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.local) != 0 {
+		t.Errorf("expected local locks to be cleaned up, got %d", len(r.local))
+	}
+}
+
+func TestLocalMutex_ContextCancelled(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-local-mutex-cancel"
+
+	holder := r.NewLocalMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	shortCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := r.NewLocalMutex(name).Lock(shortCtx); err == nil {
+		t.Fatal("expected local waiter to give up when its context is done")
+	}
+}
+
+// locker is implemented by Mutex and LocalMutex.
+type locker interface {
+	Lock(ctx context.Context) error
+	Unlock(ctx context.Context) error
+}
+
+func benchmarkContention(b *testing.B, newLocker func(r *PSLock, options ...Option) locker) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	hook := &countingHook{}
+	client := mockRedisClient()
+	client.AddHook(hook)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range b.N {
+				l := newLocker(r, WithClient(client), WithRetryDelay(10*time.Millisecond))
+				if err := l.Lock(ctx); err != nil {
+					b.Errorf("lock failed: %v", err)
+					return
+				}
+				l.Unlock(ctx)
+			}
+		}()
+	}
+	wg.Wait()
+	b.ReportMetric(float64(hook.Count())/float64(8*b.N), "cmds/op")
+}
+
+func BenchmarkMutex_InProcessContention(b *testing.B) {
+	benchmarkContention(b, func(r *PSLock, options ...Option) locker {
+		return r.NewMutex("bench-mutex-contention", options...)
+	})
+}
+
+func BenchmarkLocalMutex_InProcessContention(b *testing.B) {
+	benchmarkContention(b, func(r *PSLock, options ...Option) locker {
+		return r.NewLocalMutex("bench-local-contention", options...)
+	})
+}
@@ -0,0 +1,103 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LockAny waits for the first of the locks with given keys to be free and
+// acquires it, e.g. for a work-stealing scheduler, and returns its mutex,
+// whose Key tells which one it is. The mutexes are created with opts, and
+// the retry delay and the patient of the first one bound the wait. Free
+// locks are tried in the order of keys. While all are held, LockAny
+// subscribes to all their channels and tries a lock as soon as it is
+// unlocked, and all of them after every retry delay. Only the returned
+// lock is held; every subscription is closed before LockAny returns.
+func (r *PSLock) LockAny(ctx context.Context, keys []string, opts ...Option) (*Mutex, error) {
+	if ctx == nil {
+		return nil, ErrNilContext
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("failed to acquire any lock: no keys")
+	}
+	mutexes := make([]*Mutex, len(keys))
+	for i, key := range keys {
+		mutexes[i] = r.NewMutex(key, opts...)
+	}
+	try := func(m *Mutex) (*Mutex, error) {
+		ok, err := m.TryLock(ctx)
+		if !ok {
+			return nil, err
+		}
+		return m, nil
+	}
+	tryAll := func() (*Mutex, error) {
+		for _, m := range mutexes {
+			if m, err := try(m); m != nil || err != nil {
+				return m, err
+			}
+		}
+		return nil, nil
+	}
+	if m, err := tryAll(); m != nil || err != nil {
+		return m, err
+	}
+
+	first := mutexes[0]
+	blockCtx, cancel := context.WithTimeout(ctx, first.patient)
+	defer cancel()
+
+	// Unlock messages of all channels wake up the wait with the index of
+	// their mutex.
+	woken := make(chan int, len(mutexes))
+	for i, m := range mutexes {
+		sub, err := m.getNotifier().Subscribe(blockCtx, m.getKey())
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire lock %q: %w", m.key, err)
+		}
+		defer sub.Close()
+		// A nil channel, as of PollOnlyNotifier, or one that is never
+		// closed does not keep the forwarding past the return.
+		go func() {
+			payloads := sub.Channel()
+			for {
+				select {
+				case <-blockCtx.Done():
+					return
+				case payload, ok := <-payloads:
+					if !ok {
+						return
+					}
+					if payload != unlockPayload {
+						continue
+					}
+					select {
+					case woken <- i:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	// An unlock before the subscriptions were set up was not delivered.
+	if m, err := tryAll(); m != nil || err != nil {
+		return m, err
+	}
+	for i := 0; ; i++ {
+		select {
+		case <-blockCtx.Done():
+			return nil, fmt.Errorf("%w: %q", ErrLockTimeout, keys)
+		case <-time.After(first.retryDelay(i, 0)):
+			if m, err := tryAll(); m != nil || err != nil {
+				return m, err
+			}
+		case j := <-woken:
+			if m, err := try(mutexes[j]); m != nil || err != nil {
+				return m, err
+			}
+		}
+	}
+}
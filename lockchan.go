@@ -0,0 +1,26 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+)
+
+// LockChan acquires the lock like Lock in the background and delivers the
+// single result on the returned channel, nil once the lock is held, so
+// that the wait can be part of a select. The channel is buffered, so the
+// acquisition never blocks on a caller that stopped reading. A caller that
+// gives up should cancel ctx: if the lock is obtained after ctx is done,
+// it is released again and the context error is delivered instead, so no
+// lock is left held unnoticed.
+func (dl *Mutex) LockChan(ctx context.Context) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		err := dl.Lock(ctx)
+		if err == nil && ctx.Err() != nil {
+			dl.UnlockDefer(context.WithoutCancel(ctx))
+			err = fmt.Errorf("failed to acquire lock %q: %w", dl.key, ctx.Err())
+		}
+		result <- err
+	}()
+	return result
+}
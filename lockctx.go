@@ -0,0 +1,43 @@
+package pslock
+
+import (
+	"context"
+	"time"
+)
+
+type lockInfoKey struct{}
+
+// LockInfo identifies a held lock for correlation in logs and traces, or
+// describes the state of a lock reported by PSLock.InspectMany.
+type LockInfo struct {
+	Key   string
+	Name  string
+	Token string
+	// Held reports whether the lock is held. It is only set by
+	// InspectMany, which leaves Name empty.
+	Held bool
+	// TTL is the remaining lease of a held lock, or -1 if it has no
+	// expiry. It is only set by InspectMany.
+	TTL time.Duration
+}
+
+// LockCtx acquires the lock like Lock and returns a child of ctx carrying
+// the identity of the acquisition, which FromContext retrieves further
+// down the call chain.
+func (dl *Mutex) LockCtx(ctx context.Context) (context.Context, error) {
+	if err := dl.Lock(ctx); err != nil {
+		return nil, err
+	}
+	dl.mu.Lock()
+	info := LockInfo{Key: dl.key, Name: dl.name, Token: dl.value}
+	dl.mu.Unlock()
+	return context.WithValue(ctx, lockInfoKey{}, info), nil
+}
+
+// FromContext returns the identity of the lock acquired with LockCtx that
+// ctx descends from, the innermost one if locks were nested. It reports
+// false if there is none. The lock may have been released meanwhile.
+func FromContext(ctx context.Context) (LockInfo, bool) {
+	info, ok := ctx.Value(lockInfoKey{}).(LockInfo)
+	return info, ok
+}
@@ -0,0 +1,30 @@
+package pslock
+
+import (
+	"context"
+	"sync"
+)
+
+// Locker returns a sync.Locker view of the mutex for generic code that
+// expects one. Both methods use context.Background, so the wait is
+// bounded only by the patient of the mutex. As sync.Locker cannot report
+// errors, Lock panics if the lock cannot be acquired, like a failed
+// assertion, while Unlock reports failures to the logger of the mutex as
+// UnlockDefer does. Prefer the context-taking methods where possible.
+func (dl *Mutex) Locker() sync.Locker {
+	return syncLocker{dl}
+}
+
+type syncLocker struct {
+	m *Mutex
+}
+
+func (l syncLocker) Lock() {
+	if err := l.m.Lock(context.Background()); err != nil {
+		panic(err)
+	}
+}
+
+func (l syncLocker) Unlock() {
+	l.m.UnlockDefer(context.Background())
+}
@@ -0,0 +1,54 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// casScript replaces the lock value with ARGV[2] if the key still holds
+// ARGV[1], an empty ARGV[1] standing for a free lock.
+var casScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if (current or "") ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+return 1
+`)
+
+// LockIf takes over the lock if predicate returns true for the value of
+// the current holder, e.g. to replace a holder known to be stale. A free
+// lock is acquired without calling predicate. The predicate runs on the
+// client; the takeover then only commits if the value did not change in
+// the meantime, and LockIf returns false otherwise. It never waits.
+func (dl *Mutex) LockIf(ctx context.Context, predicate func(currentValue string) bool) (bool, error) {
+	if dl.pslock.draining.Load() {
+		return false, ErrDraining
+	}
+
+	current, err := dl.client.Get(ctx, dl.getKey()).Result()
+	if err != nil && err != redis.Nil {
+		return false, fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+	}
+	if current != "" && !predicate(current) {
+		return false, nil
+	}
+
+	l, err := dl.newLease(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+	}
+	n, err := withOpTimeout(ctx, dl, func(ctx context.Context) (int, error) {
+		return casScript.Run(ctx, dl.client, []string{dl.getKey()}, current, l.value, l.expiry.Milliseconds()).Int()
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+	dl.acquired(l)
+	return true, nil
+}
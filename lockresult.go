@@ -0,0 +1,52 @@
+package pslock
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// LockResult describes how LockWithResult acquired the lock.
+type LockResult struct {
+	// Contended reports whether the first attempt found the lock held, so
+	// that the acquisition went through the blocking flow.
+	Contended bool
+	// Tries is the number of acquisition attempts made, 1 on the fast
+	// path and 0 if WithEnabledKey skipped locking.
+	Tries int
+}
+
+// lockTrace records the course of a LockWithResult, carried by its ctx.
+type lockTrace struct {
+	tries     atomic.Int64
+	contended atomic.Bool
+}
+
+type lockTraceKey struct{}
+
+// countAttempt counts an acquisition attempt if ctx comes from
+// LockWithResult.
+func countAttempt(ctx context.Context) {
+	if t, ok := ctx.Value(lockTraceKey{}).(*lockTrace); ok {
+		t.tries.Add(1)
+	}
+}
+
+// markContended records that the acquisition entered the blocking flow if
+// ctx comes from LockWithResult.
+func markContended(ctx context.Context) {
+	if t, ok := ctx.Value(lockTraceKey{}).(*lockTrace); ok {
+		t.contended.Store(true)
+	}
+}
+
+// LockWithResult acquires the lock like Lock and also reports whether it
+// was contended and how many attempts it took, e.g. to log contention
+// without an Observer. The result is also set when Lock fails.
+func (dl *Mutex) LockWithResult(ctx context.Context) (LockResult, error) {
+	if ctx == nil {
+		return LockResult{}, dl.Lock(ctx)
+	}
+	t := &lockTrace{}
+	err := dl.Lock(context.WithValue(ctx, lockTraceKey{}, t))
+	return LockResult{Contended: t.contended.Load(), Tries: int(t.tries.Load())}, err
+}
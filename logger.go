@@ -0,0 +1,13 @@
+package pslock
+
+import "log"
+
+// A Logger is used to report problems that cannot be returned as errors,
+// such as a lock that was force-released in the background.
+// *log.Logger satisfies this interface.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// defaultLogger writes to the standard logger of the log package.
+var defaultLogger Logger = log.Default()
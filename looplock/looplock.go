@@ -42,6 +42,7 @@ func (r PSLock) NewMutex(key string, options ...Option) *Mutex {
 		delayFunc: func(tries int) time.Duration {
 			return time.Duration(rand.Intn(maxRetryDelayMilliSec-minRetryDelayMilliSec)+minRetryDelayMilliSec) * time.Millisecond
 		},
+		clock: realClock{},
 	}
 	for _, o := range options {
 		o.Apply(m)
@@ -79,7 +80,8 @@ func WithExpiry(expiry time.Duration) Option {
 }
 
 // WithTries can be used to set the number of times lock acquire is attempted.
-// The default value is 32.
+// Lock returns ErrLockTimeout once they are exhausted, right after the first
+// attempt for tries <= 1. The default value is 32.
 func WithTries(tries int) Option {
 	return OptionFunc(func(m *Mutex) {
 		m.tries = tries
@@ -102,3 +104,26 @@ func WithRetryDelayFunc(delayFunc DelayFunc) Option {
 		m.delayFunc = delayFunc
 	})
 }
+
+// WithExponentialBackoff can be used to back off exponentially between
+// retries, from base up to max, see ExponentialBackoff, so that long
+// waits poll Redis less often.
+func WithExponentialBackoff(base, max time.Duration) Option {
+	return WithRetryDelayFunc(ExponentialBackoff(base, max))
+}
+
+// WithClock can be used to replace the time source of the retry loop,
+// e.g. with a fake clock in tests. The default uses the time package.
+func WithClock(clock Clock) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.clock = clock
+	})
+}
+
+// WithNowFunc can be used to override only the current time of the retry
+// loop, while waiting between retries still uses real timers.
+func WithNowFunc(now func() time.Time) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.clock = nowFuncClock(now)
+	})
+}
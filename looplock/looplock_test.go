@@ -0,0 +1,234 @@
+package looplock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func mockRedisClient() *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+}
+
+// fakeClock advances its time by d on every After(d) and fires immediately.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	ch <- now
+	return ch
+}
+
+func TestLock_RetryCountWithFakeClock(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+
+	holder := r.NewMutex("looplock-fake-clock")
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	var mu sync.Mutex
+	var attempts int
+	waiter := r.NewMutex("looplock-fake-clock",
+		WithClock(&fakeClock{now: time.Unix(0, 0)}),
+		WithTries(200),
+		WithRetryDelayFunc(func(tries int) time.Duration {
+			mu.Lock()
+			attempts++
+			mu.Unlock()
+			return 100 * time.Millisecond
+		}),
+	)
+
+	if err := waiter.Lock(ctx); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout while lock is held, got %v", err)
+	}
+	// The default patient of 8s is exhausted after 80 delays of 100ms.
+	if attempts != 80 {
+		t.Errorf("expected 80 retry delays, got %d", attempts)
+	}
+}
+
+func TestLock_TriesExhaustedWithFakeClock(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+
+	holder := r.NewMutex("looplock-fake-clock-tries")
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	var mu sync.Mutex
+	var attempts int
+	waiter := r.NewMutex("looplock-fake-clock-tries",
+		WithClock(&fakeClock{now: time.Unix(0, 0)}),
+		WithTries(5),
+		WithRetryDelayFunc(func(tries int) time.Duration {
+			mu.Lock()
+			attempts++
+			mu.Unlock()
+			return 100 * time.Millisecond
+		}),
+	)
+
+	if err := waiter.Lock(ctx); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout once tries are exhausted, got %v", err)
+	}
+	if attempts != 4 {
+		t.Errorf("expected 4 retry delays, got %d", attempts)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(100*time.Millisecond, time.Second)
+	want := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for tries, w := range want {
+		if got := backoff(tries); got != w {
+			t.Errorf("expected delay %v before retry %d, got %v", w, tries, got)
+		}
+	}
+	if got := backoff(100); got != time.Second {
+		t.Errorf("expected the delay to stay capped, got %v", got)
+	}
+}
+
+// recordingClock is a fakeClock that records the delays waited for.
+type recordingClock struct {
+	fakeClock
+	delays []time.Duration
+}
+
+func (c *recordingClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	c.delays = append(c.delays, d)
+	c.mu.Unlock()
+	return c.fakeClock.After(d)
+}
+
+func TestLock_ExponentialBackoffWithFakeClock(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+
+	holder := r.NewMutex("looplock-exponential-backoff")
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	clock := &recordingClock{fakeClock: fakeClock{now: time.Unix(0, 0)}}
+	waiter := r.NewMutex("looplock-exponential-backoff",
+		WithClock(clock),
+		WithTries(200),
+		WithExponentialBackoff(100*time.Millisecond, 1600*time.Millisecond),
+	)
+	if err := waiter.Lock(ctx); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout while lock is held, got %v", err)
+	}
+
+	// The default patient of 8s runs out during the ninth delay, which is
+	// cut short at the deadline.
+	want := []time.Duration{100, 200, 400, 800, 1600, 1600, 1600, 1600, 100}
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if len(clock.delays) != len(want) {
+		t.Fatalf("expected %d retry delays, got %v", len(want), clock.delays)
+	}
+	for i, w := range want {
+		if clock.delays[i] != w*time.Millisecond {
+			t.Errorf("expected delay %v before retry %d, got %v", w*time.Millisecond, i, clock.delays[i])
+		}
+	}
+}
+
+func TestLock_NoTriesWithFakeClock(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+
+	holder := r.NewMutex("looplock-fake-clock-no-tries")
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	clock := &recordingClock{fakeClock: fakeClock{now: time.Unix(0, 0)}}
+	waiter := r.NewMutex("looplock-fake-clock-no-tries", WithClock(clock), WithTries(0))
+	if err := waiter.Lock(ctx); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout without tries, got %v", err)
+	}
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if len(clock.delays) != 0 {
+		t.Errorf("expected no retry delays, got %v", clock.delays)
+	}
+}
+
+func TestLock_DelayBeyondPatientWithFakeClock(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+
+	holder := r.NewMutex("looplock-fake-clock-long-delay")
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	clock := &recordingClock{fakeClock: fakeClock{now: time.Unix(0, 0)}}
+	waiter := r.NewMutex("looplock-fake-clock-long-delay", WithClock(clock), WithRetryDelay(time.Minute))
+	if err := waiter.Lock(ctx); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout after the patient, got %v", err)
+	}
+	// The wait ends at the default patient of 8s, not after the delay.
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if len(clock.delays) != 1 || clock.delays[0] != 8*time.Second {
+		t.Errorf("expected a single wait of 8s, got %v", clock.delays)
+	}
+}
+
+func TestLock_ContextCancelled(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+
+	holder := r.NewMutex("looplock-context-cancelled")
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	waiter := r.NewMutex("looplock-context-cancelled", WithRetryDelay(time.Second))
+	if err := waiter.Lock(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+}
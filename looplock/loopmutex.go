@@ -2,6 +2,7 @@ package looplock
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,9 +13,28 @@ const (
 	lockPrefix = "distributed_lock:"
 )
 
+// ErrLockTimeout is returned by Lock when the lock could not be obtained
+// within the patient or the number of tries.
+var ErrLockTimeout = errors.New("lock acquisition timeout")
+
 // A DelayFunc is used to decide the amount of time to wait between retries.
 type DelayFunc func(tries int) time.Duration
 
+// ExponentialBackoff returns a DelayFunc that waits base before the first
+// retry and doubles the delay on every further retry, up to max.
+func ExponentialBackoff(base, max time.Duration) DelayFunc {
+	return func(tries int) time.Duration {
+		delay := base
+		for range tries {
+			if delay >= max/2 {
+				return max
+			}
+			delay *= 2
+		}
+		return min(delay, max)
+	}
+}
+
 // Mutex represents a distributed lock implementation
 type Mutex struct {
 	client *redis.Client
@@ -26,8 +46,29 @@ type Mutex struct {
 
 	tries     int
 	delayFunc DelayFunc
+	clock     Clock
 }
 
+// A Clock provides the time source used by the retry loop.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the default Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// nowFuncClock is a Clock whose current time comes from a user function.
+type nowFuncClock func() time.Time
+
+func (f nowFuncClock) Now() time.Time { return f() }
+
+func (nowFuncClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
 // Name returns mutex name (i.e. the Redis key).
 func (m *Mutex) Name() string {
 	return m.name
@@ -93,38 +134,41 @@ func (dl *Mutex) blockingLock(ctx context.Context) error {
 
 	// msgCh := sub.Channel()
 
-	// Create a context with timeout for the entire blocking operation
-	blockCtx, cancel := context.WithTimeout(ctx, dl.patient)
+	// The patient deadline is measured on the mutex clock so that a fake
+	// clock can drive the whole retry loop in tests. No wait runs past it.
+	blockCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	deadline := dl.clock.Now().Add(dl.patient)
 
 	// Start polling attempts
 	pollDone := make(chan struct{})
 	msgDone := make(chan struct{})
+	timedOut := make(chan struct{})
 
 	go func() {
-		for i := range dl.tries {
-			if i == dl.tries-1 {
-				close(pollDone)
-				return
-			}
-
+		// The first attempt was made by Lock.
+		for i := range max(dl.tries-1, 0) {
+			delay := min(dl.delayFunc(i), deadline.Sub(dl.clock.Now()))
 			select {
 			case <-blockCtx.Done():
 				return
 			case <-msgDone:
 				close(pollDone)
 				return
-			case <-time.After(dl.delayFunc(i)):
+			case <-dl.clock.After(delay):
 				// fmt.Printf("id: %s, try %d\n", dl.name, i)
+				if !dl.clock.Now().Before(deadline) {
+					close(timedOut)
+					return
+				}
 				success, err := dl.client.SetNX(blockCtx, lockKey, "1", dl.expiry).Result()
 				if err == nil && success {
 					close(pollDone)
-					cancel()
-
 					return
 				}
 			}
 		}
+		close(timedOut)
 	}()
 
 	// Wait for either polling success or unlock notification
@@ -137,7 +181,9 @@ func (dl *Mutex) blockingLock(ctx context.Context) error {
 	// 	close(msgDone)
 	// 	return dl.Lock(blockCtx)
 	// return nil
-	case <-blockCtx.Done():
-		return fmt.Errorf("lock acquisition timeout")
+	case <-timedOut:
+		return fmt.Errorf("%w: %q", ErrLockTimeout, dl.key)
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
@@ -0,0 +1,160 @@
+package pslock
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// A ValueCodec encodes the token and the context metadata of a mutex
+// created with WithContextMetadata into the lock value. Marshal must be
+// deterministic, since ownership checks compare the whole value.
+type ValueCodec interface {
+	Marshal(token string, metadata map[string]string) ([]byte, error)
+	Unmarshal(data []byte) (token string, metadata map[string]string, err error)
+}
+
+// lockValue is the JSON form of a lock value carrying metadata.
+type lockValue struct {
+	Token    string            `json:"token"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// JSONCodec encodes the lock value as a JSON object holding the token and
+// the metadata. It is the default.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(token string, metadata map[string]string) ([]byte, error) {
+	return json.Marshal(lockValue{Token: token, Metadata: metadata})
+}
+
+func (JSONCodec) Unmarshal(data []byte) (string, map[string]string, error) {
+	var v lockValue
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", nil, err
+	}
+	return v.Token, v.Metadata, nil
+}
+
+// binaryValueMagic starts every value of BinaryCodec. It is not printable,
+// so it never starts a JSON value.
+const binaryValueMagic = 0x01
+
+var errInvalidBinaryValue = errors.New("invalid binary lock value")
+
+// BinaryCodec encodes the lock value compactly as a magic byte followed by
+// the length-prefixed token and the length-prefixed metadata keys and
+// values, sorted by key.
+type BinaryCodec struct{}
+
+func (BinaryCodec) Marshal(token string, metadata map[string]string) ([]byte, error) {
+	b := []byte{binaryValueMagic}
+	b = appendString(b, token)
+	b = binary.AppendUvarint(b, uint64(len(metadata)))
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		b = appendString(b, k)
+		b = appendString(b, metadata[k])
+	}
+	return b, nil
+}
+
+func (BinaryCodec) Unmarshal(data []byte) (string, map[string]string, error) {
+	if len(data) == 0 || data[0] != binaryValueMagic {
+		return "", nil, errInvalidBinaryValue
+	}
+	data = data[1:]
+	token, data, ok := readString(data)
+	if !ok {
+		return "", nil, errInvalidBinaryValue
+	}
+	n, size := binary.Uvarint(data)
+	if size <= 0 || n > uint64(len(data)) {
+		return "", nil, errInvalidBinaryValue
+	}
+	data = data[size:]
+	metadata := make(map[string]string, n)
+	for range n {
+		var k, v string
+		if k, data, ok = readString(data); !ok {
+			return "", nil, errInvalidBinaryValue
+		}
+		if v, data, ok = readString(data); !ok {
+			return "", nil, errInvalidBinaryValue
+		}
+		metadata[k] = v
+	}
+	if len(data) > 0 {
+		return "", nil, errInvalidBinaryValue
+	}
+	return token, metadata, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// readString reads a length-prefixed string off the front of data.
+func readString(data []byte) (string, []byte, bool) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || n > uint64(len(data)-size) {
+		return "", nil, false
+	}
+	data = data[size:]
+	return string(data[:n]), data[n:], true
+}
+
+// encodeMetadata wraps token with the values of the metadata keys found in
+// ctx using the value codec. Keys and values are formatted with fmt.Sprint.
+func (dl *Mutex) encodeMetadata(ctx context.Context, token string) (string, error) {
+	metadata := make(map[string]string, len(dl.metadataKeys))
+	for _, k := range dl.metadataKeys {
+		if val := ctx.Value(k); val != nil {
+			metadata[fmt.Sprint(k)] = fmt.Sprint(val)
+		}
+	}
+	codec := dl.valueCodec
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	b, err := codec.Marshal(token, metadata)
+	return string(b), err
+}
+
+// Metadata returns the context metadata embedded in the value of the lock
+// with given key by a mutex created with WithContextMetadata. It returns
+// ErrLockNotHeld if the lock is not held, and nil metadata if the holder
+// did not embed any. Values of JSONCodec and BinaryCodec are told apart by
+// their first byte; values of other codecs are reported without metadata.
+// Options such as WithKeyEncoding must match those of the holder.
+func (r *PSLock) Metadata(ctx context.Context, key string, options ...Option) (map[string]string, error) {
+	client, encoded := r.locate(key, options)
+	value, err := client.Get(ctx, lockPrefix+encoded).Result()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("%w: %q", ErrLockNotHeld, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata of lock %q: %w", key, err)
+	}
+
+	value = trimEpoch(trimStealable(value))
+	var codec ValueCodec = JSONCodec{}
+	if len(value) > 0 && value[0] == binaryValueMagic {
+		codec = BinaryCodec{}
+	}
+	_, metadata, err := codec.Unmarshal([]byte(value))
+	if err != nil {
+		return nil, nil
+	}
+	return metadata, nil
+}
@@ -0,0 +1,69 @@
+package pslock
+
+import (
+	"context"
+	"maps"
+	"testing"
+)
+
+func TestValueCodec_RoundTrip(t *testing.T) {
+	codecs := map[string]ValueCodec{"json": JSONCodec{}, "binary": BinaryCodec{}}
+	values := []struct {
+		token    string
+		metadata map[string]string
+	}{
+		{"token", map[string]string{}},
+		{"token", map[string]string{"trace_id": "trace-1", "request_id": "req-1"}},
+		{"", map[string]string{"": "", "k\x00": "v ü"}},
+	}
+	for name, codec := range codecs {
+		for _, v := range values {
+			data, err := codec.Marshal(v.token, v.metadata)
+			if err != nil {
+				t.Fatalf("%s: marshal failed: %v", name, err)
+			}
+			again, _ := codec.Marshal(v.token, maps.Clone(v.metadata))
+			if string(again) != string(data) {
+				t.Errorf("%s: expected a deterministic encoding, got %q and %q", name, data, again)
+			}
+			token, metadata, err := codec.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("%s: unmarshal failed: %v", name, err)
+			}
+			if token != v.token || !maps.Equal(metadata, v.metadata) {
+				t.Errorf("%s: expected %q, %v, got %q, %v", name, v.token, v.metadata, token, metadata)
+			}
+		}
+	}
+
+	if _, _, err := (BinaryCodec{}).Unmarshal([]byte("\x01\x05ab")); err == nil {
+		t.Error("expected a truncated binary value to fail")
+	}
+}
+
+func TestMutex_ValueCodec(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.WithValue(context.Background(), testContextKey("trace_id"), "trace-1")
+	name := "test-mutex-value-codec"
+
+	mutex := r.NewMutex(name, WithContextMetadata(testContextKey("trace_id")), WithValueCodec(BinaryCodec{}))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	value, _ := client.Get(ctx, mutex.getKey()).Result()
+	token, metadata, err := (BinaryCodec{}).Unmarshal([]byte(value))
+	if err != nil || token == "" {
+		t.Fatalf("expected a binary lock value, got %q, %v", value, err)
+	}
+	if json, _ := (JSONCodec{}).Marshal(token, metadata); len(value) >= len(json) {
+		t.Errorf("expected the binary value to be smaller than JSON, got %d and %d bytes", len(value), len(json))
+	}
+	metadata, err = r.Metadata(ctx, name)
+	if err != nil || metadata["trace_id"] != "trace-1" {
+		t.Errorf("expected the binary metadata to be decoded, got %v, %v", metadata, err)
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MonitorAll subscribes to the channels of all locks and to the expired
+// keyspace events of their clients, and delivers a unified stream of the
+// release events of every lock, acquired events of mutexes created with
+// WithAcquireEvents and expirations of lock keys, e.g. for a live lock
+// dashboard. Keys are reported as used in Redis, i.e. after
+// WithKeyEncoding. Expirations are only delivered if keyspace
+// notifications are enabled, see OnExpired. The channel is closed when ctx
+// is done or the PSLock is closed.
+func (r *PSLock) MonitorAll(ctx context.Context) (<-chan LockEvent, error) {
+	var subs []*redis.PubSub
+	for _, c := range r.clients() {
+		sub := c.PSubscribe(ctx, lockPrefix+"*")
+		err := sub.Subscribe(ctx, expiredChannel(c))
+		for range 2 {
+			if err != nil {
+				break
+			}
+			_, err = sub.Receive(ctx)
+		}
+		if err != nil {
+			sub.Close()
+			for _, s := range subs {
+				s.Close()
+			}
+			return nil, fmt.Errorf("failed to monitor locks: %w", err)
+		}
+		subs = append(subs, sub)
+	}
+
+	events := make(chan LockEvent)
+	var wg sync.WaitGroup
+	for _, sub := range subs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sub.Close()
+			r.monitor(ctx, sub, events)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+	return events, nil
+}
+
+// monitor delivers the events received on sub until ctx is done or the
+// PSLock is closed.
+func (r *PSLock) monitor(ctx context.Context, sub *redis.PubSub, events chan<- LockEvent) {
+	msgCh := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.closed:
+			return
+		case msg, ok := <-msgCh:
+			if !ok {
+				return
+			}
+			var event LockEvent
+			switch {
+			case msg.Pattern == "":
+				// An expired event names the key in its payload.
+				key, ok := strings.CutPrefix(msg.Payload, lockPrefix)
+				if !ok {
+					continue
+				}
+				event = LockEvent{Key: key, Event: EventExpired}
+			case msg.Payload == unlockPayload:
+				event = LockEvent{Key: strings.TrimPrefix(msg.Channel, lockPrefix), Event: EventReleased}
+			case isAcquiredPayload(msg.Payload):
+				event = LockEvent{
+					Key:    strings.TrimPrefix(msg.Channel, lockPrefix),
+					Event:  EventAcquired,
+					Holder: strings.TrimPrefix(msg.Payload, acquiredPayload),
+				}
+			default:
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			case <-r.closed:
+				return
+			}
+		}
+	}
+}
@@ -2,7 +2,15 @@ package pslock
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math/rand"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -12,11 +20,63 @@ const (
 	lockPrefix = "distributed_lock:"
 )
 
+// ErrLockNotHeld is returned by Unlock when the lock is no longer held with
+// the value written by this mutex, e.g. after it expired and was taken by
+// another holder. Redis is left untouched in that case.
+var ErrLockNotHeld = errors.New("lock not held")
+
+// ErrLockTimeout is returned by Lock when the lock could not be obtained
+// within the patient window.
+var ErrLockTimeout = errors.New("lock acquisition timeout")
+
+// ErrTooManyWaiters is returned by Lock with WithMaxWaiters when too many
+// mutexes already wait for the lock.
+var ErrTooManyWaiters = errors.New("too many waiters for lock")
+
+// ErrSelfLock is returned by Lock with WithDetectSelfLock when the lock is
+// already held by a mutex of the same PSLock.
+var ErrSelfLock = errors.New("lock already held by this process")
+
+// ErrLockExpired is passed to the lost callback when a lock is still held
+// locally after its expiry in Redis lapsed, so another holder may exist.
+var ErrLockExpired = errors.New("lock expired while held")
+
+// ErrNilContext is returned by Lock, Unlock, Extend and TryLock when they
+// are called with a nil context, which go-redis would panic on.
+var ErrNilContext = errors.New("nil context")
+
+// unlockScript deletes the lock only if it still holds our value, or the
+// optional ARGV[2] replaced by a token rotation. It is mirrored in
+// functionLibrary.
+var unlockScript = redis.NewScript(`
+local v = redis.call("GET", KEYS[1])
+if v == ARGV[1] or (ARGV[2] and ARGV[2] ~= "" and v == ARGV[2]) then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// acquireScript acquires a free lock. With ARGV[3] set to "1" it also
+// resumes a lock already held with the same value, refreshing its TTL.
+// It returns {1, 0} on success, {2, 0} on resume and {0, PTTL of the
+// holder} otherwise.
+var acquireScript = redis.NewScript(`
+if ARGV[3] == "1" and redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return {2, 0}
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return {1, 0}
+end
+return {0, redis.call("PTTL", KEYS[1])}
+`)
+
 // A DelayFunc is used to decide the amount of time to wait between retries.
 type DelayFunc func(tries int) time.Duration
 
 // Mutex represents a distributed lock implementation
 type Mutex struct {
+	pslock *PSLock
 	client *redis.Client
 	// The maximum waiting time if the lock is not obtained
 	patient time.Duration
@@ -26,6 +86,157 @@ type Mutex struct {
 
 	tries     int
 	delayFunc DelayFunc
+
+	logger Logger
+	// Receives structured records of Lock and Unlock if set
+	slog *slog.Logger
+	// Is notified of every Lock and Unlock if set
+	observer Observer
+	// The options the mutex was created with, replayed by Clone
+	options []Option
+	// The maximum time the lock may be held before it is force-released
+	maxHold   time.Duration
+	onMaxHold func(m *Mutex)
+	// A stable holder identity written instead of a per-acquisition token
+	ownerID string
+	// Tells the mutex apart from the other mutexes of its key in OpStats
+	instanceID string
+	// Caps the TTL-based retry delay, 0 disables adaptive waiting
+	adaptiveDelay time.Duration
+	// The floor of every retry delay
+	minRetryDelay time.Duration
+	// The fraction by which each acquisition perturbs the expiry
+	expiryJitter float64
+	// Lock logs a warning when blocked for longer than this, 0 disables it
+	deadlockWarn time.Duration
+	// The parent a child lock places its intent on, and whether a parent
+	// writer waits for child intents to clear
+	intentParent string
+	intentCheck  bool
+	// Whether a hold that outlives its expiry is reported, and the
+	// callback invoked when the lock is lost
+	expiryWarn bool
+	onLost     func(ctx context.Context, m *Mutex, err error)
+	// Whether a held lock is extended in the background until Unlock
+	autoRenew bool
+	// The rank in the lock order checked on Lock, if ranked
+	ranked    bool
+	orderRank int
+	// The goroutine that acquired the lock, guarded by the PSLock
+	rankGoroutine uint64
+	// The bounds of the expiry chosen from the RTT, unset if max is 0
+	adaptiveExpiryMin time.Duration
+	adaptiveExpiryMax time.Duration
+	// The last expiry chosen from the RTT, for logging changes
+	adaptiveExpiryLast atomic.Int64
+	// Whether unlock, extend and TTL use the Redis function library
+	functions bool
+	// The flag key gating the locking, always enforced if empty
+	enabledKey string
+	// Draws the default retry delays and the expiry jitter
+	rand *rand.Rand
+	// Creates the token of each acquisition, genToken if nil
+	tokenGen func() (string, error)
+	// Whether the polling attempts of a wait use a dedicated connection
+	dedicatedConn bool
+	// The interval of the extra poll while subscribed, 0 disables it
+	safetyPoll time.Duration
+	// Whether waiters also wake up on expired events of the lock key
+	expiredEvents bool
+	// Decides before each retry of a blocked Lock whether to go on
+	retryDecider func(ctx context.Context, try int, elapsed time.Duration) bool
+	// Lock fails instead of waiting when this many mutexes already wait
+	maxWaiters int
+	// Fails Lock fast after repeated Redis failures if set
+	breaker *breaker
+	// Whether Lock fails when a mutex of the same PSLock holds the lock
+	detectSelfLock bool
+	// The number of events kept in the history list, 0 disables it
+	historyLen int
+	// The TTL of the waiter counter, handoff list and history list if set
+	auxTTL time.Duration
+	// Context keys whose values are embedded in the lock value
+	metadataKeys []any
+	// Encodes the lock value carrying metadata, JSONCodec if nil
+	valueCodec ValueCodec
+	// Acquisitions not acknowledged by all replicas within it are undone
+	maxReplicationLag time.Duration
+	// Whether a StealLock of a higher priority may take the lock
+	stealable bool
+	priority  int
+	// The watchdog stops renewing after this long without Touch if set
+	idleTimeout time.Duration
+	// Whether locks are scoped to the epoch of BumpEpoch
+	epoch bool
+	// Receives the lifecycle events through sinkQueue if set
+	eventSink EventSink
+	// Identifies the queue of eventSink in its PSLock
+	eventSinkKey any
+	sinkQueue    *sinkQueue
+	// The safety margin taken off the remaining lease for clock skew
+	clockSkew time.Duration
+	// Whether cancelling the context of Lock releases the lock
+	autoUnlockOnCancel bool
+	// The expiry of holds of AcquireProvisional until commit
+	provisional time.Duration
+	// Whether waiters block on a handoff list instead of pub/sub
+	handoff bool
+	// Maps the key to the string used in Redis, the key itself if nil
+	keyEncoding KeyEncoding
+	// Whether contended acquisitions are published on the lock channel
+	acquireEvents bool
+	// Delivers unlock notifications, pub/sub on client if nil
+	notifier Notifier
+	// Caps the pub/sub connections shared by waiters, 0 disables sharing
+	maxSubscriptions int
+	// Bounds the Redis operations of Unlock
+	unlockTimeout time.Duration
+	// Bounds each single Redis operation, 0 disables it
+	opTimeout time.Duration
+	// Whether Unlock fails when the unlock notification cannot be published
+	strictPublish bool
+	// How often a failed publish of the unlock notification is retried
+	publishRetries int
+	// How often a transient Redis error is retried during acquisition
+	transientRetries int
+	// Decides whether a message on the lock channel signals an unlock
+	unlockFilter func(payload string) bool
+
+	// The number of acquisition attempts of the current Lock
+	attempts atomic.Int64
+
+	mu sync.Mutex
+	// The unique value and expiry written on the current acquisition
+	value      string
+	heldExpiry time.Duration
+	// Whether the current hold awaits the commit of AcquireProvisional
+	heldProvisional bool
+	acquiredAt      time.Time
+	holdTimer       *time.Timer
+	lapseTimer      *time.Timer
+	// Stops the renewal watchdog of the current hold
+	renewCancel context.CancelFunc
+	// The job progress of the current hold given to ReportProgress
+	progress float64
+	// The last activity recorded by Touch or the acquisition
+	touched time.Time
+	// The epoch of the current hold with WithEpoch
+	heldEpoch string
+	// When the current hold expires by the local clock, before clockSkew
+	until time.Time
+	// Stops the release of the current hold on cancel of WithAutoUnlockOnCancel
+	stopUnlockOnCancel func() bool
+	// Whether the loss of the current hold was reported
+	lostReported bool
+	// Set while the lock is held through LockWithRelease
+	lostTimer  *time.Timer
+	lostCancel context.CancelFunc
+	// Whether the current Lock was skipped by WithEnabledKey
+	bypassed bool
+	// The value replaced by the last token rotation, accepted until
+	// prevUntil
+	prevValue string
+	prevUntil time.Time
 }
 
 // Name returns mutex name (i.e. the Redis key).
@@ -33,79 +244,777 @@ func (m *Mutex) Name() string {
 	return m.name
 }
 
+// Key returns the lock key the mutex was created with, which differs from
+// Name if WithName is given.
+func (m *Mutex) Key() string {
+	return m.key
+}
+
+// Clone returns a new mutex with the same key and options as m, with opts
+// applied on top. The clone does not share any hold state with m.
+func (m *Mutex) Clone(opts ...Option) *Mutex {
+	options := append(slices.Clip(m.options), opts...)
+	return m.pslock.NewMutex(m.key, options...)
+}
+
+// WithKey returns a new mutex for key with the same options as m. Unless
+// m was given an explicit name, the new mutex is named after key.
+func (m *Mutex) WithKey(key string) *Mutex {
+	return m.pslock.NewMutex(key, m.options...)
+}
+
 // Lock attempts to acquire a distributed lock
-func (dl *Mutex) Lock(ctx context.Context) error {
-	lockKey := lockPrefix + dl.key
+func (dl *Mutex) Lock(ctx context.Context) (err error) {
+	if ctx == nil {
+		return fmt.Errorf("%w: %q", ErrNilContext, dl.key)
+	}
+	if dl.enabledKey != "" && !dl.pslock.lockingEnabled(ctx, dl.client, dl.enabledKey) {
+		dl.mu.Lock()
+		dl.bypassed = true
+		dl.mu.Unlock()
+		return nil
+	}
+	if dl.autoUnlockOnCancel {
+		defer func() {
+			if err == nil {
+				dl.unlockOnCancel(ctx)
+			}
+		}()
+	}
+	if dl.observer != nil {
+		var done func(error)
+		ctx, done = dl.observe(ctx, "lock")
+		defer func() { done(err) }()
+	}
+	if dl.slog != nil {
+		start := time.Now()
+		dl.attempts.Store(0)
+		defer func() { dl.logLock(ctx, start, err) }()
+	}
+	if dl.breaker != nil {
+		if !dl.breaker.allow() {
+			return fmt.Errorf("%w: %q", ErrCircuitOpen, dl.key)
+		}
+		defer func() { dl.breaker.record(err) }()
+	}
+	if dl.deadlockWarn > 0 {
+		defer dl.warnIfBlocked()()
+	}
+	if !dl.ranked {
+		return dl.lock(ctx, false)
+	}
+
+	dl.checkOrder()
+	if err = dl.lock(ctx, false); err != nil {
+		return err
+	}
+	dl.holdRanked()
+	return nil
+}
+
+// warnIfBlocked logs the key and the caller's stack if the acquisition has
+// not completed within deadlockWarn. The returned func stops the timer.
+func (dl *Mutex) warnIfBlocked() func() {
+	stack := debug.Stack()
+	timer := time.AfterFunc(dl.deadlockWarn, func() {
+		dl.logger.Printf("pslock: Lock of %q blocked for more than %v, possible deadlock\n%s", dl.key, dl.deadlockWarn, stack)
+	})
+	return func() {
+		timer.Stop()
+	}
+}
+
+// lock implements Lock. contended is set when retrying from the blocking
+// flow, so that the acquisition is reported as such.
+func (dl *Mutex) lock(ctx context.Context, contended bool) error {
+	if dl.pslock.draining.Load() {
+		return ErrDraining
+	}
+	if dl.adaptiveExpiryMax > 0 && !contended {
+		ctx = context.WithValue(ctx, adaptiveExpiryKey{}, new(atomic.Int64))
+	}
+
+	l, err := dl.newLease(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+	}
 
 	// Try to acquire the lock using SETNX
-	success, err := dl.client.SetNX(ctx, lockKey, "1", dl.expiry).Result()
+	stopFastPath := startPhase(ctx, PhaseFastPath)
+	success, ttl, err := dl.tryAcquire(ctx, &l)
+	stopFastPath()
 	// fmt.Println("got lock:", dl.name, lockKey, success)
 
 	if err != nil {
-		return fmt.Errorf("failed to acquire lock: %w", err)
+		return fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
 	}
 
 	if success {
+		dl.acquired(l)
+		if contended {
+			dl.publishAcquired(ctx)
+		}
 		return nil
 	}
 
+	if dl.detectSelfLock && !contended {
+		if err := dl.checkSelfLock(ctx); err != nil {
+			return err
+		}
+	}
+	if dl.maxWaiters > 0 && !contended {
+		if err := dl.checkWaiters(ctx); err != nil {
+			return err
+		}
+	}
+
 	// If lock acquisition failed, enter blocking flow
-	return dl.blockingLock(ctx)
+	markContended(ctx)
+	if dl.handoff {
+		return dl.handoffLock(ctx, l, ttl)
+	}
+	return dl.blockingLock(ctx, l, ttl)
+}
+
+// checkSelfLock returns ErrSelfLock if the lock is held with a value that
+// was written by a mutex of the same PSLock, which would wait for itself.
+func (dl *Mutex) checkSelfLock(ctx context.Context) error {
+	value, err := withOpTimeout(ctx, dl, func(ctx context.Context) (string, error) {
+		return dl.client.Get(ctx, dl.getKey()).Result()
+	})
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+	}
+	if dl.pslock.holder(value) != nil {
+		return fmt.Errorf("%w: %q", ErrSelfLock, dl.key)
+	}
+	return nil
+}
+
+// AddToPipe queues the acquisition of the lock as a SET NX on pipe and
+// returns the queued command, so that it can be pipelined with other work.
+// After Exec, a true result means the lock is held and can be released
+// with Unlock. There is no waiting in this mode, and the max hold and
+// LockWithRelease bookkeeping are not started.
+func (dl *Mutex) AddToPipe(pipe redis.Pipeliner) *redis.BoolCmd {
+	// Queued commands are sent on Exec, which takes its own context.
+	ctx := context.Background()
+	if dl.pslock.draining.Load() {
+		cmd := redis.NewBoolCmd(ctx)
+		cmd.SetErr(ErrDraining)
+		return cmd
+	}
+
+	l, err := dl.newLease(ctx)
+	if err != nil {
+		cmd := redis.NewBoolCmd(ctx)
+		cmd.SetErr(fmt.Errorf("failed to acquire lock %q: %w", dl.key, err))
+		return cmd
+	}
+
+	dl.mu.Lock()
+	dl.value = l.value
+	dl.mu.Unlock()
+	return pipe.SetNX(ctx, dl.getKey(), l.value, l.expiry)
 }
 
 func (dl *Mutex) getKey() string {
-	return lockPrefix + dl.key
+	return lockPrefix + dl.keyEncoding.encode(dl.key)
+}
+
+// tryAcquire attempts the acquisition once, retrying transient Redis
+// errors, and records the start of the attempt in l. When the lock is
+// held, the remaining TTL of the holder is returned if the mutex waits
+// adaptively, and 0 otherwise.
+func (dl *Mutex) tryAcquire(ctx context.Context, l *lease) (bool, time.Duration, error) {
+	return dl.tryAcquireOn(ctx, nil, l)
+}
+
+// tryAcquireOn is tryAcquire sending the commands on the pinned connection
+// pin, or on the client of the mutex if pin is nil.
+func (dl *Mutex) tryAcquireOn(ctx context.Context, pin *pinnedConn, l *lease) (bool, time.Duration, error) {
+	dl.attempts.Add(1)
+	countAttempt(ctx)
+	var reply acquireReply
+	recheck := false
+	err := dl.withTransientRetries(ctx, func() error {
+		var err error
+		l.start = time.Now()
+		reply, err = dl.acquireOn(ctx, pin, *l, recheck)
+		recheck = recheck || mayHaveApplied(err)
+		return err
+	})
+	if err != nil && recheck && dl.ownerID == "" {
+		// Do not leave behind a lock an attempt may have taken.
+		ctx := context.WithoutCancel(ctx)
+		if err := doWithOpTimeout(ctx, dl, func(ctx context.Context) error {
+			return unlockScript.Run(ctx, dl.client, []string{dl.getKey()}, l.value).Err()
+		}); err != nil {
+			dl.logger.Printf("pslock: failed to undo acquisition of lock %q: %v", dl.key, err)
+		}
+	}
+	return reply.success, reply.ttl, err
 }
 
-// Unlock releases the distributed lock
-func (dl *Mutex) Unlock(ctx context.Context) error {
+// acquireOn makes a single acquisition attempt on pin like tryAcquireOn
+// and, with WithMaxReplicationLag, confirms it on the same connection,
+// since WAIT only counts the writes made on its own connection. With
+// recheck, a lock already held with the value of the lease counts as
+// acquired.
+func (dl *Mutex) acquireOn(ctx context.Context, pin *pinnedConn, l lease, recheck bool) (acquireReply, error) {
+	if pin == nil && dl.maxReplicationLag > 0 {
+		pin = pinConn(dl.client)
+		defer pin.Close()
+	}
+	if pin == nil {
+		return withOpTimeout(ctx, dl, func(ctx context.Context) (acquireReply, error) {
+			return dl.acquireOnce(ctx, dl.client, l, recheck)
+		})
+	}
+
+	reply, err := withPinnedOpTimeout(ctx, dl, pin, func(ctx context.Context, c redis.Cmdable) (acquireReply, error) {
+		return dl.acquireOnce(ctx, c, l, recheck)
+	})
+	if err == nil && reply.success && dl.maxReplicationLag > 0 {
+		if err := dl.confirmReplicated(ctx, pin.conn, l, reply.resumed); err != nil {
+			return acquireReply{}, err
+		}
+	}
+	return reply, err
+}
+
+// acquireReply is the outcome of a single acquisition attempt.
+type acquireReply struct {
+	success bool
+	// Whether the lock was already held with the owner ID
+	resumed bool
+	ttl     time.Duration
+}
+
+func (dl *Mutex) acquireOnce(ctx context.Context, c redis.Cmdable, l lease, recheck bool) (acquireReply, error) {
+	if dl.ownerID == "" && dl.adaptiveDelay <= 0 && !dl.usesIntents() && !recheck {
+		success, err := c.SetNX(ctx, dl.getKey(), l.value, l.expiry).Result()
+		return acquireReply{success: success}, err
+	}
+
+	resume := "0"
+	if dl.ownerID != "" || recheck {
+		resume = "1"
+	}
+	var res []int64
+	var err error
+	if dl.usesIntents() {
+		res, err = dl.tryAcquireIntent(ctx, c, l, resume)
+	} else {
+		res, err = acquireScript.Run(ctx, c, []string{dl.getKey()}, l.value, l.expiry.Milliseconds(), resume).Int64Slice()
+	}
+	if err != nil {
+		return acquireReply{}, err
+	}
+	// Without an owner ID, only the attempt that failed can have taken
+	// the lock with the fresh token of the lease.
+	resumed := res[0] == 2 && dl.ownerID != ""
+	return acquireReply{success: res[0] >= 1, resumed: resumed, ttl: time.Duration(res[1]) * time.Millisecond}, nil
+}
+
+// retryDelay returns the time to wait before retry i. An adaptive mutex
+// sleeps until the holder's lease runs out, capped at adaptiveDelay. The
+// delay never drops below minRetryDelay.
+func (dl *Mutex) retryDelay(i int, ttl time.Duration) time.Duration {
+	if dl.adaptiveDelay > 0 && ttl > 0 {
+		return max(min(ttl, dl.adaptiveDelay), dl.minRetryDelay)
+	}
+	return max(dl.delayFunc(i), dl.minRetryDelay)
+}
+
+// A lease is what a single acquisition writes to Redis.
+type lease struct {
+	value  string
+	expiry time.Duration
+	// The epoch the lock is scoped to with WithEpoch
+	epoch string
+	// Whether the lease is a provisional one of AcquireProvisional
+	provisional bool
+	// When the last attempt to write the lease started, which the local
+	// estimate of its expiry counts from
+	start time.Time
+}
+
+// newLease returns the lease for a new acquisition. Every acquisition
+// writes a fresh token so that a stale Unlock can never release a later
+// hold, unless an owner ID identifies the holder across restarts. The
+// token is wrapped with the context metadata, if configured, and marked
+// with the epoch and if the lock is stealable. A lease of
+// AcquireProvisional has the provisional expiry.
+func (dl *Mutex) newLease(ctx context.Context) (lease, error) {
+	l := lease{value: dl.ownerID, expiry: dl.jitteredExpiry()}
+	if dl.adaptiveExpiryMax > 0 {
+		l.expiry = dl.adaptiveExpiry(ctx)
+	}
+	if p, ok := ctx.Value(provisionalKey{}).(*provisionalLease); ok {
+		p.committed = l.expiry
+		l.expiry = min(l.expiry, dl.provisional)
+		l.provisional = true
+	}
+	if l.value == "" {
+		gen := dl.tokenGen
+		if gen == nil {
+			gen = genToken
+		}
+		var err error
+		if l.value, err = gen(); err != nil {
+			return l, err
+		}
+		if l.value == "" {
+			return l, errEmptyToken
+		}
+	}
+	if len(dl.metadataKeys) > 0 {
+		var err error
+		if l.value, err = dl.encodeMetadata(ctx, l.value); err != nil {
+			return l, err
+		}
+	}
+	if dl.epoch {
+		var err error
+		if l.epoch, err = dl.currentEpoch(ctx); err != nil {
+			return l, err
+		}
+		l.value = epochValue(l.epoch, l.value)
+	}
+	if strings.HasPrefix(l.value, stealablePrefix) {
+		return l, errStealablePrefix
+	}
+	if dl.stealable {
+		l.value = dl.stealableValue(l.value)
+	}
+	l.start = time.Now()
+	return l, nil
+}
+
+// jitteredExpiry perturbs the expiry by up to ±expiryJitter, so that
+// locks taken at the same time do not all expire at once.
+func (dl *Mutex) jitteredExpiry() time.Duration {
+	if dl.expiryJitter <= 0 {
+		return dl.expiry
+	}
+	f := (dl.rand.Float64()*2 - 1) * dl.expiryJitter
+	return dl.expiry + time.Duration(f*float64(dl.expiry))
+}
+
+// adaptiveExpiryRTTs is how many round trips to Redis an adaptive expiry
+// spans before it is clamped to its bounds.
+const adaptiveExpiryRTTs = 1000
+
+// adaptiveExpiryKey is the context key of the expiry picked for a Lock,
+// which the retries of its blocking flow reuse.
+type adaptiveExpiryKey struct{}
+
+// adaptiveExpiry picks the expiry of an acquisition from the RTT of a
+// PING, clamped between the bounds of WithAdaptiveExpiry. A failed PING
+// counts as a slow Redis and picks the maximum. The expiry is picked once
+// per Lock.
+func (dl *Mutex) adaptiveExpiry(ctx context.Context) time.Duration {
+	picked, _ := ctx.Value(adaptiveExpiryKey{}).(*atomic.Int64)
+	if picked != nil {
+		if expiry := time.Duration(picked.Load()); expiry > 0 {
+			return expiry
+		}
+	}
+
+	start := time.Now()
+	err := doWithOpTimeout(ctx, dl, func(ctx context.Context) error {
+		return dl.client.Ping(ctx).Err()
+	})
+	rtt := time.Since(start)
+
+	expiry := dl.adaptiveExpiryMax
+	if err == nil {
+		expiry = min(max(rtt*adaptiveExpiryRTTs, dl.adaptiveExpiryMin), dl.adaptiveExpiryMax)
+	}
+	if last := time.Duration(dl.adaptiveExpiryLast.Swap(int64(expiry))); last != expiry {
+		dl.logger.Printf("pslock: lock %q uses expiry %v for RTT %v", dl.key, expiry, rtt)
+	}
+	if picked != nil {
+		picked.Store(int64(expiry))
+	}
+	return expiry
+}
+
+// acquired records a successful acquisition and starts the max hold and
+// expiry lapse timers and the renewal watchdog.
+func (dl *Mutex) acquired(l lease) {
+	dl.pslock.track(dl, l.value)
+	dl.emit(EventAcquired, l.value)
+	if dl.historyLen > 0 {
+		dl.recordHistory(context.Background(), EventAcquired)
+	}
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.value = l.value
+	dl.heldExpiry = l.expiry
+	dl.heldProvisional = l.provisional
+	dl.acquiredAt = time.Now()
+	dl.lostReported = false
+	dl.progress = 0
+	dl.touched = dl.acquiredAt
+	dl.heldEpoch = l.epoch
+	dl.until = l.start.Add(l.expiry)
+	if dl.autoRenew {
+		if dl.renewCancel != nil {
+			dl.renewCancel()
+		}
+		var renewCtx context.Context
+		renewCtx, dl.renewCancel = context.WithCancel(context.Background())
+		go dl.watchdog(renewCtx, l.value, l.expiry)
+	}
+	if dl.expiryWarn {
+		if dl.lapseTimer != nil {
+			dl.lapseTimer.Stop()
+		}
+		value := l.value
+		dl.lapseTimer = time.AfterFunc(l.expiry, func() { dl.expiryLapsed(value) })
+	}
+	if dl.maxHold <= 0 {
+		return
+	}
+	if dl.holdTimer != nil {
+		dl.holdTimer.Stop()
+	}
+	dl.holdTimer = time.AfterFunc(dl.maxHold, dl.maxHoldExceeded)
+}
+
+// maxHoldExceeded force-releases a lock that was held longer than maxHold.
+func (dl *Mutex) maxHoldExceeded() {
+	dl.logger.Printf("pslock: lock %q held longer than %v without Unlock, force releasing", dl.name, dl.maxHold)
+	if dl.onMaxHold != nil {
+		dl.onMaxHold(dl)
+	}
+	if err := dl.Unlock(context.Background()); err != nil {
+		dl.logger.Printf("pslock: force release of lock %q failed: %v", dl.name, err)
+	}
+}
+
+// expiryLapsed reports a hold with value that is still held locally
+// although its expiry in Redis has passed.
+func (dl *Mutex) expiryLapsed(value string) {
+	dl.mu.Lock()
+	expiry := dl.heldExpiry
+	dl.lapseTimer = nil
+	dl.mu.Unlock()
+	dl.lost(value, fmt.Errorf("%w: %q after %v", ErrLockExpired, dl.key, expiry))
+}
+
+// stopReaper stops the max hold timer, the expiry lapse timer and the
+// renewal watchdog of the current hold, if any.
+func (dl *Mutex) stopReaper() {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.stopReaperLocked()
+}
+
+// stopReaperLocked is stopReaper with dl.mu held.
+func (dl *Mutex) stopReaperLocked() {
+	if dl.holdTimer != nil {
+		dl.holdTimer.Stop()
+		dl.holdTimer = nil
+	}
+	if dl.lapseTimer != nil {
+		dl.lapseTimer.Stop()
+		dl.lapseTimer = nil
+	}
+	if dl.renewCancel != nil {
+		dl.renewCancel()
+		dl.renewCancel = nil
+	}
+}
+
+// released stops all local state tied to the current hold and returns
+// its value, and the value replaced by a token rotation if still accepted.
+func (dl *Mutex) released() (string, string) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return dl.releasedLocked()
+}
+
+// releasedLocked is released with dl.mu held.
+func (dl *Mutex) releasedLocked() (string, string) {
+	if dl.ranked {
+		dl.releaseRanked()
+	}
+	dl.stopReaperLocked()
+
+	value, previous := dl.tokensFor(dl.value)
+	dl.value, dl.prevValue = "", ""
+	dl.acquiredAt = time.Time{}
+	dl.until = time.Time{}
+	dl.pslock.untrack(dl, value)
+	if dl.lostTimer != nil {
+		dl.lostTimer.Stop()
+		dl.lostTimer = nil
+	}
+	if dl.lostCancel != nil {
+		dl.lostCancel()
+		dl.lostCancel = nil
+	}
+	if dl.stopUnlockOnCancel != nil {
+		dl.stopUnlockOnCancel()
+		dl.stopUnlockOnCancel = nil
+	}
+	return value, previous
+}
+
+// unlockOnCancel releases the current hold once ctx is done, unless it is
+// unlocked before. The hold is released by its own token, so a later hold
+// is left alone even if it is taken while the release runs.
+func (dl *Mutex) unlockOnCancel(ctx context.Context) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	held := dl.value
+	if dl.stopUnlockOnCancel != nil {
+		dl.stopUnlockOnCancel()
+	}
+	dl.stopUnlockOnCancel = context.AfterFunc(ctx, func() {
+		dl.mu.Lock()
+		if dl.value != held {
+			dl.mu.Unlock()
+			return
+		}
+		value, previous := dl.releasedLocked()
+		dl.mu.Unlock()
+
+		ctx, cancel := dl.withUnlockTimeout(context.WithoutCancel(ctx))
+		defer cancel()
+		if err := dl.release(ctx, value, previous); err != nil {
+			dl.logger.Printf("pslock: unlock of lock %q failed: %v", dl.name, err)
+		}
+	})
+}
+
+// LockWithRelease acquires the lock like Lock and ties the hold to the
+// returned context. The context is cancelled once the lock is lost, i.e.
+// when the expiry lapses, the lock is force-released or it is unlocked.
+// Calling release unlocks the lock; errors are reported to the logger.
+func (dl *Mutex) LockWithRelease(ctx context.Context) (context.Context, func(), error) {
+	if err := dl.Lock(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	dl.mu.Lock()
+	dl.lostCancel = cancel
+	if !dl.autoRenew {
+		dl.lostTimer = time.AfterFunc(dl.heldExpiry, cancel)
+	}
+	dl.mu.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			if err := dl.Unlock(context.WithoutCancel(ctx)); err != nil {
+				dl.logger.Printf("pslock: release of lock %q failed: %v", dl.name, err)
+			}
+			cancel()
+		})
+	}
+	return lockCtx, release, nil
+}
+
+// Unlock releases the distributed lock.
+//
+// The lock is marked released locally right away. The Redis operations are
+// bounded by the unlock timeout, so a hung Redis cannot wedge a deferred
+// Unlock; on timeout an error wrapping context.DeadlineExceeded is returned.
+func (dl *Mutex) Unlock(ctx context.Context) (err error) {
+	if ctx == nil {
+		return fmt.Errorf("%w: %q", ErrNilContext, dl.key)
+	}
+	dl.mu.Lock()
+	bypassed := dl.bypassed
+	dl.bypassed = false
+	dl.mu.Unlock()
+	if bypassed {
+		return nil
+	}
+	if dl.observer != nil {
+		var done func(error)
+		ctx, done = dl.observe(ctx, "unlock")
+		defer func() { done(err) }()
+	}
+	if dl.slog != nil {
+		start := time.Now()
+		defer func() { dl.logUnlock(ctx, start, err) }()
+	}
+	value, previous := dl.released()
+
+	ctx, cancel := dl.withUnlockTimeout(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- dl.release(ctx, value, previous)
+	}()
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("failed to release lock %q: %w", dl.key, ctx.Err())
+	}
+}
+
+// withUnlockTimeout bounds ctx by the unlock timeout, if any.
+func (dl *Mutex) withUnlockTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if dl.unlockTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, dl.unlockTimeout)
+}
+
+// UnlockDefer releases the lock like Unlock for use in a deferred call,
+// defer m.UnlockDefer(ctx), where the error of Unlock would be dropped.
+// A failure, such as ErrLockNotHeld after the lock expired, is reported
+// to the logger of the mutex instead of being returned.
+func (dl *Mutex) UnlockDefer(ctx context.Context) {
+	if err := dl.Unlock(ctx); err != nil {
+		dl.logger.Printf("pslock: unlock of lock %q failed: %v", dl.name, err)
+	}
+}
+
+// release deletes the lock key if it still holds value, or the previous
+// value of a token rotation, and notifies waiters.
+func (dl *Mutex) release(ctx context.Context, value, previous string) error {
 	lockKey := dl.getKey()
 
-	// Delete the lock key
-	_, err := dl.client.Del(ctx, lockKey).Result()
+	// Delete the lock key if it is still ours
+	n, err := withOpTimeout(ctx, dl, func(ctx context.Context) (int, error) {
+		return dl.runScript(ctx, unlockScript, "pslock_unlock", []string{lockKey}, value, previous).Int()
+	})
 	if err != nil {
-		return fmt.Errorf("failed to release lock: %w", err)
+		return fmt.Errorf("failed to release lock %q: %w", dl.key, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %q", ErrLockNotHeld, dl.key)
+	}
+	dl.emit(EventReleased, value)
+	dl.releaseIntent(ctx, value)
+	if dl.historyLen > 0 {
+		dl.recordHistory(ctx, EventReleased)
+	}
+	if dl.handoff {
+		dl.handOff(ctx)
 	}
 
 	// fmt.Printf("id: %s release key\n", dl.name)
 	// Publish unlock message to notify waiting goroutines
-	err = dl.client.Publish(ctx, lockKey, "unlock").Err()
+	// A repeated unlock message is harmless, unlike a repeated delete, so
+	// only the publish is retried.
+	err = withRetries(ctx, dl.publishRetries, isPublishRetryable, func() error {
+		return doWithOpTimeout(ctx, dl, func(ctx context.Context) error {
+			return dl.getNotifier().Publish(ctx, lockKey, unlockPayload)
+		})
+	})
 	if err != nil {
-		return fmt.Errorf("failed to publish unlock message: %w", err)
+		err = fmt.Errorf("failed to publish unlock message for lock %q: %w", dl.key, err)
+		if dl.strictPublish {
+			return err
+		}
+		// The lock is released already; waiters fall back to polling.
+		dl.logger.Printf("pslock: %v", err)
 	}
 	// fmt.Printf("id: %s pub mes\n", dl.name)
 
 	return nil
 }
 
+// safetyAttempt is an acquisition attempt of the safety poll.
+func (dl *Mutex) safetyAttempt(ctx context.Context, l *lease) bool {
+	defer startPhase(ctx, PhasePoll)()
+	success, _, err := dl.tryAcquire(ctx, l)
+	return err == nil && success
+}
+
 // blockingLock implements the blocking flow for lock acquisition
-func (dl *Mutex) blockingLock(ctx context.Context) error {
+func (dl *Mutex) blockingLock(ctx context.Context, l lease, ttl time.Duration) error {
 	lockKey := dl.getKey()
+	ctx, ws := waitStateFor(ctx)
+
+	leaveWaiters := dl.enterWaiters(ctx)
+	defer leaveWaiters()
 
-	// Subscribe to Redis channel for unlock notifications
-	sub := dl.client.Subscribe(ctx, lockKey)
+	// Subscribe to the lock channel for unlock notifications
+	stopSubscribe := startPhase(ctx, PhaseSubscribe)
+	sub, err := dl.getNotifier().Subscribe(ctx, lockKey)
+	if err != nil {
+		stopSubscribe()
+		dl.logger.Printf("pslock: failed to subscribe to lock %q: %v", dl.key, err)
+		return fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+	}
 	defer sub.Close()
 
-	if _, err := sub.Receive(ctx); err != nil {
-		fmt.Printf("sub error: %v\n", err)
+	// An unlock between the failed attempt and the subscription was not
+	// delivered, so the lock is checked again once the subscription is
+	// confirmed.
+	success, _, err := dl.tryAcquire(ctx, &l)
+	stopSubscribe()
+	if err == nil && success {
+		dl.acquired(l)
+		dl.publishAcquired(ctx)
 		return nil
 	}
 
 	msgCh := sub.Channel()
 
+	// An expiry publishes no unlock message, but an expired event if
+	// enabled.
+	var expiredCh <-chan string
+	if dl.expiredEvents {
+		expiredSub, err := NewRedisNotifier(dl.client).Subscribe(ctx, expiredChannel(dl.client))
+		if err != nil {
+			dl.logger.Printf("pslock: failed to subscribe to expired events of lock %q: %v", dl.key, err)
+		} else {
+			defer expiredSub.Close()
+			expiredCh = expiredSub.Channel()
+		}
+	}
+
 	// Create a context with timeout for the entire blocking operation
 	blockCtx, cancel := context.WithTimeout(ctx, dl.patient)
 	defer cancel()
 
+	// The safety poll retries at its own interval, independent of the
+	// tries.
+	var safetyTick <-chan time.Time
+	if dl.safetyPoll > 0 {
+		ticker := time.NewTicker(dl.safetyPoll)
+		defer ticker.Stop()
+		safetyTick = ticker.C
+	}
+
 	// Start polling attempts
 	pollDone := make(chan struct{})
 	msgDone := make(chan struct{})
+	pollAcquired := false
+	pollAborted := false
+	pollExhausted := false
+	// The polling attempts record their start in a lease of their own.
+	pollLease := l
 
 	go func() {
+		// The attempts run on a connection of their own if requested,
+		// which goes back to the pool when the polling stops.
+		var pin *pinnedConn
+		if dl.dedicatedConn {
+			pin = pinConn(dl.client)
+			defer pin.Close()
+		}
+
 		for i := range dl.tries {
 			if i == dl.tries-1 {
-				close(pollDone)
-				return
+				break
 			}
 
 			select {
@@ -114,30 +1023,92 @@ func (dl *Mutex) blockingLock(ctx context.Context) error {
 			case <-msgDone:
 				close(pollDone)
 				return
-			case <-time.After(dl.delayFunc(i)):
+			case <-time.After(dl.retryDelay(i, ttl)):
 				// fmt.Printf("id: %s, try %d\n", dl.name, i)
-				success, err := dl.client.SetNX(blockCtx, lockKey, "1", dl.expiry).Result()
+				if !dl.decideRetry(blockCtx, ws) {
+					pollAborted = true
+					close(pollDone)
+					return
+				}
+				var success bool
+				var err error
+				stopPoll := startPhase(blockCtx, PhasePoll)
+				success, ttl, err = dl.tryAcquireOn(blockCtx, pin, &pollLease)
+				stopPoll()
 				if err == nil && success {
+					pollAcquired = true
 					close(pollDone)
-					cancel()
 
 					return
 				}
 			}
 		}
+		pollExhausted = true
+		close(pollDone)
 	}()
 
 	// Wait for either polling success or unlock notification
-	select {
-	case <-pollDone:
-		// Polling succeeded, cancel subscription
-		return nil
-	case <-msgCh:
-		// fmt.Printf("id: %s, got mes\n", dl.name)
+	stopWait := startPhase(blockCtx, PhaseWait)
+	defer func() { stopWait() }()
+	retry := func() error {
 		close(msgDone)
-		return dl.Lock(blockCtx)
-		// return nil
-	case <-blockCtx.Done():
-		return fmt.Errorf("lock acquisition timeout")
+		// The retry times its own phases
+		stopWait()
+		stopWait = func() {}
+		// The retry counts itself if it has to wait again
+		leaveWaiters()
+		if !dl.decideRetry(blockCtx, ws) {
+			return fmt.Errorf("%w: %q", ErrRetryAborted, dl.key)
+		}
+		return dl.lock(blockCtx, true)
+	}
+	for {
+		select {
+		case <-pollDone:
+			if pollAborted {
+				return fmt.Errorf("%w: %q", ErrRetryAborted, dl.key)
+			}
+			if pollExhausted {
+				return fmt.Errorf("%w: %q", ErrLockTimeout, dl.key)
+			}
+			// Polling succeeded, cancel subscription
+			if pollAcquired {
+				dl.acquired(pollLease)
+				dl.publishAcquired(ctx)
+			}
+			return nil
+		case payload, ok := <-msgCh:
+			// fmt.Printf("id: %s, got mes\n", dl.name)
+			if !ok {
+				// The notifier closed the subscription, keep polling
+				msgCh = nil
+				continue
+			}
+			if isAcquiredPayload(payload) {
+				// Another waiter took the lock
+				continue
+			}
+			if dl.unlockFilter != nil && !dl.unlockFilter(payload) {
+				// Unrelated traffic on the lock channel
+				continue
+			}
+			return retry()
+		case key := <-expiredCh:
+			if key != lockKey {
+				// Another key of the database expired
+				continue
+			}
+			return retry()
+		case <-safetyTick:
+			if dl.safetyAttempt(blockCtx, &l) {
+				// Stop the polling attempts
+				close(msgDone)
+				dl.acquired(l)
+				dl.publishAcquired(ctx)
+				return nil
+			}
+		case <-blockCtx.Done():
+			return fmt.Errorf("%w: %q", ErrLockTimeout, dl.key)
+		}
 	}
 }
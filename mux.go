@@ -0,0 +1,294 @@
+package pslock
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// muxKey identifies a subscription multiplexer of a PSLock.
+type muxKey struct {
+	client *redis.Client
+	conns  int
+}
+
+// subscriptionMux is a Notifier that multiplexes the subscriptions of many
+// waiters over a bounded number of pub/sub connections. Each channel is
+// subscribed on the connection picked by its hash, once no matter how many
+// waiters listen on it, and messages are demultiplexed by channel.
+// Channel subscriptions are used rather than patterns, so that a
+// connection only receives the traffic of contended keys.
+type subscriptionMux struct {
+	client *redis.Client
+	shards []*muxShard
+}
+
+func newSubscriptionMux(c *redis.Client, conns int) *subscriptionMux {
+	m := &subscriptionMux{client: c, shards: make([]*muxShard, conns)}
+	for i := range m.shards {
+		m.shards[i] = &muxShard{
+			client:    c,
+			subs:      make(map[string]map[*muxSubscription]struct{}),
+			confirmed: make(map[string]bool),
+			pending:   make(map[string]map[*muxSubscription]struct{}),
+			inflight:  make(map[string]int),
+			done:      make(chan struct{}),
+		}
+	}
+	return m
+}
+
+func (m *subscriptionMux) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	h := fnv.New32a()
+	h.Write([]byte(channel))
+	return m.shards[h.Sum32()%uint32(len(m.shards))].subscribe(ctx, channel)
+}
+
+func (m *subscriptionMux) Publish(ctx context.Context, channel, payload string) error {
+	return m.client.Publish(ctx, channel, payload).Err()
+}
+
+// close closes the pub/sub connections. The channels of open subscriptions
+// are closed and further subscriptions fail with redis.ErrClosed.
+func (m *subscriptionMux) close() {
+	for _, s := range m.shards {
+		s.ops.Lock()
+		s.mu.Lock()
+		pubsub := s.pubsub
+		if !s.closed {
+			s.closed = true
+			close(s.done)
+			for _, waiters := range s.subs {
+				for sub := range waiters {
+					close(sub.payloads)
+				}
+			}
+			// Closing the subscriptions later finds nothing to remove.
+			clear(s.subs)
+			clear(s.confirmed)
+			clear(s.pending)
+		}
+		s.mu.Unlock()
+		if pubsub != nil {
+			pubsub.Close()
+		}
+		s.ops.Unlock()
+	}
+}
+
+// muxShard owns one pub/sub connection of a subscriptionMux.
+type muxShard struct {
+	client *redis.Client
+
+	// Serializes the SUBSCRIBE and UNSUBSCRIBE commands of the connection
+	// in the order their changes to subs are made, without holding mu, and
+	// so message delivery, across the round trip.
+	ops sync.Mutex
+
+	mu sync.Mutex
+	// Opened on the first subscription
+	pubsub  *redis.PubSub
+	running bool
+	closed  bool
+	// Closed by close
+	done chan struct{}
+	// The waiters of each subscribed channel
+	subs map[string]map[*muxSubscription]struct{}
+	// Whether Redis confirmed the subscription of a channel
+	confirmed map[string]bool
+	// The waiters still waiting for Redis to confirm their channel
+	pending map[string]map[*muxSubscription]struct{}
+	// The SUBSCRIBE commands of each channel Redis has not replied to yet
+	inflight map[string]int
+}
+
+func (s *muxShard) subscribe(ctx context.Context, channel string) (Subscription, error) {
+	sub := &muxSubscription{
+		shard:     s,
+		channel:   channel,
+		payloads:  make(chan string, 1),
+		confirmed: make(chan struct{}),
+	}
+
+	s.ops.Lock()
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		s.ops.Unlock()
+		return nil, redis.ErrClosed
+	}
+	waiters, ok := s.subs[channel]
+	if !ok {
+		waiters = make(map[*muxSubscription]struct{})
+		s.subs[channel] = waiters
+	}
+	waiters[sub] = struct{}{}
+	if s.confirmed[channel] {
+		s.mu.Unlock()
+		s.ops.Unlock()
+		return sub, nil
+	}
+	if s.pending[channel] == nil {
+		s.pending[channel] = make(map[*muxSubscription]struct{})
+	}
+	s.pending[channel][sub] = struct{}{}
+	if s.pubsub == nil {
+		s.pubsub = s.client.Subscribe(context.Background())
+	}
+	pubsub := s.pubsub
+	s.mu.Unlock()
+
+	if !ok {
+		s.mu.Lock()
+		s.inflight[channel]++
+		s.mu.Unlock()
+		if err := pubsub.Subscribe(ctx, channel); err != nil {
+			// Nobody else joined the channel while ops was held.
+			s.mu.Lock()
+			s.replied(channel)
+			s.forget(sub)
+			s.mu.Unlock()
+			s.ops.Unlock()
+			return nil, err
+		}
+		s.mu.Lock()
+		if !s.running {
+			s.running = true
+			go s.run(pubsub.ChannelWithSubscriptions())
+		}
+		s.mu.Unlock()
+	}
+	s.ops.Unlock()
+
+	// Messages are only delivered once Redis has processed the SUBSCRIBE.
+	select {
+	case <-sub.confirmed:
+		return sub, nil
+	case <-s.done:
+		sub.Close()
+		return nil, redis.ErrClosed
+	case <-ctx.Done():
+		sub.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// run delivers the messages of the connection to the waiters of their
+// channel until the connection is closed. A waiter that has not consumed
+// the previous message misses the next one, like with a dropped message.
+func (s *muxShard) run(msgs <-chan any) {
+	for msg := range msgs {
+		s.mu.Lock()
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind == "subscribe" {
+				if s.replied(msg.Channel) > 0 {
+					// The reply to a SUBSCRIBE of waiters that left
+					// before it arrived; the newer one is yet to come.
+					break
+				}
+				if _, ok := s.subs[msg.Channel]; !ok {
+					// Unsubscribed before the confirmation arrived.
+					break
+				}
+				s.confirmed[msg.Channel] = true
+				for sub := range s.pending[msg.Channel] {
+					close(sub.confirmed)
+				}
+				delete(s.pending, msg.Channel)
+			}
+		case *redis.Message:
+			for sub := range s.subs[msg.Channel] {
+				select {
+				case sub.payloads <- msg.Payload:
+				default:
+				}
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// remove unregisters sub and unsubscribes its channel once it has no
+// waiters left.
+func (s *muxShard) remove(sub *muxSubscription) error {
+	s.ops.Lock()
+	defer s.ops.Unlock()
+	s.mu.Lock()
+	if _, ok := s.subs[sub.channel][sub]; !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	last := s.forget(sub)
+	pubsub := s.pubsub
+	s.mu.Unlock()
+	if !last {
+		return nil
+	}
+	return pubsub.Unsubscribe(context.Background(), sub.channel)
+}
+
+// replied accounts for a reply to a SUBSCRIBE of channel with s.mu held
+// and returns the number of SUBSCRIBE commands still awaiting theirs.
+// Replies to the resubscriptions of a reconnect are not counted.
+func (s *muxShard) replied(channel string) int {
+	n := s.inflight[channel] - 1
+	if n <= 0 {
+		delete(s.inflight, channel)
+		return 0
+	}
+	s.inflight[channel] = n
+	return n
+}
+
+// forget unregisters sub with s.mu held and reports whether its channel
+// has no waiters left.
+func (s *muxShard) forget(sub *muxSubscription) bool {
+	waiters := s.subs[sub.channel]
+	delete(waiters, sub)
+	close(sub.payloads)
+	if pending := s.pending[sub.channel]; pending != nil {
+		delete(pending, sub)
+		if len(pending) == 0 {
+			delete(s.pending, sub.channel)
+		}
+	}
+	if len(waiters) > 0 {
+		return false
+	}
+	delete(s.subs, sub.channel)
+	delete(s.confirmed, sub.channel)
+	return true
+}
+
+type muxSubscription struct {
+	shard    *muxShard
+	channel  string
+	payloads chan string
+	// Closed once Redis confirmed the subscription of the channel
+	confirmed chan struct{}
+}
+
+func (s *muxSubscription) Channel() <-chan string {
+	return s.payloads
+}
+
+func (s *muxSubscription) Close() error {
+	return s.shard.remove(s)
+}
+
+// subscriptionMux returns the multiplexer of the PSLock for c with given
+// number of connections, creating it on first use.
+func (r *PSLock) subscriptionMux(c *redis.Client, conns int) *subscriptionMux {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := muxKey{client: c, conns: conns}
+	m, ok := r.muxes[key]
+	if !ok {
+		m = newSubscriptionMux(c, conns)
+		r.muxes[key] = m
+	}
+	return m
+}
@@ -0,0 +1,106 @@
+package pslock
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// A Notifier delivers lock release notifications from Unlock to waiting
+// mutexes. Waiters always poll as well, so a Notifier only speeds up
+// wakeups and may drop messages.
+type Notifier interface {
+	// Subscribe starts listening on channel. Messages published after
+	// Subscribe returns must be delivered on the subscription.
+	Subscribe(ctx context.Context, channel string) (Subscription, error)
+	// Publish sends payload to all subscribers of channel.
+	Publish(ctx context.Context, channel, payload string) error
+}
+
+// A Subscription receives the payloads published on a channel.
+type Subscription interface {
+	Channel() <-chan string
+	Close() error
+}
+
+// NewRedisNotifier returns a Notifier using Redis pub/sub on c. This is
+// the default for a mutex, on the client the mutex uses.
+func NewRedisNotifier(c *redis.Client) Notifier {
+	return redisNotifier{client: c}
+}
+
+type redisNotifier struct {
+	client *redis.Client
+}
+
+func (n redisNotifier) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	sub := n.client.Subscribe(ctx, channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	payloads := make(chan string)
+	go func() {
+		defer close(payloads)
+		// The message channel is closed by sub.Close.
+		for msg := range sub.Channel() {
+			payloads <- msg.Payload
+		}
+	}()
+	return &redisSubscription{sub: sub, payloads: payloads}, nil
+}
+
+func (n redisNotifier) Publish(ctx context.Context, channel, payload string) error {
+	return n.client.Publish(ctx, channel, payload).Err()
+}
+
+type redisSubscription struct {
+	sub      *redis.PubSub
+	payloads chan string
+}
+
+func (s *redisSubscription) Channel() <-chan string {
+	return s.payloads
+}
+
+func (s *redisSubscription) Close() error {
+	err := s.sub.Close()
+	// Drain so the forwarding goroutine can exit.
+	go func() {
+		for range s.payloads {
+		}
+	}()
+	return err
+}
+
+// PollOnlyNotifier is a Notifier for backends without pub/sub. It never
+// delivers anything, so waiters acquire released locks by polling only.
+type PollOnlyNotifier struct{}
+
+func (PollOnlyNotifier) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	return pollOnlySubscription{}, nil
+}
+
+func (PollOnlyNotifier) Publish(ctx context.Context, channel, payload string) error {
+	return nil
+}
+
+type pollOnlySubscription struct{}
+
+func (pollOnlySubscription) Channel() <-chan string {
+	return nil
+}
+
+func (pollOnlySubscription) Close() error {
+	return nil
+}
+
+// getNotifier returns the configured Notifier or pub/sub on the mutex
+// client, reusing the subscriptions of PreSubscribe.
+func (dl *Mutex) getNotifier() Notifier {
+	if dl.notifier != nil {
+		return dl.notifier
+	}
+	return presubNotifier{Notifier: NewRedisNotifier(dl.client), pslock: dl.pslock, client: dl.client}
+}
@@ -0,0 +1,68 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LockOrObserve acquires the lock if it is free. Otherwise it waits like
+// Lock, but when it is woken up by the holder's unlock notification it
+// returns false instead of acquiring the lock: the holder is assumed to
+// have done the work, as with a cache refresh that other callers wait
+// for. If the lock becomes free without a notification, e.g. because the
+// holder crashed and the lock expired, polling acquires it and returns
+// true, so the work is not lost. Unlike Lock, a false result with a nil
+// error means the lock is not held.
+func (dl *Mutex) LockOrObserve(ctx context.Context) (bool, error) {
+	if dl.pslock.draining.Load() {
+		return false, ErrDraining
+	}
+	l, err := dl.newLease(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+	}
+
+	success, ttl, err := dl.tryAcquire(ctx, &l)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+	}
+	if success {
+		dl.acquired(l)
+		return true, nil
+	}
+
+	defer dl.enterWaiters(ctx)()
+
+	sub, err := dl.getNotifier().Subscribe(ctx, dl.getKey())
+	if err != nil {
+		return false, fmt.Errorf("failed to subscribe to lock %q: %w", dl.key, err)
+	}
+	defer sub.Close()
+	msgCh := sub.Channel()
+
+	blockCtx, cancel := context.WithTimeout(ctx, dl.patient)
+	defer cancel()
+
+	for i := range dl.tries {
+		select {
+		case <-blockCtx.Done():
+			return false, fmt.Errorf("%w: %q", ErrLockTimeout, dl.key)
+		case payload, ok := <-msgCh:
+			if !ok {
+				return false, fmt.Errorf("failed to observe lock %q: subscription closed", dl.key)
+			}
+			if isAcquiredPayload(payload) || (dl.unlockFilter != nil && !dl.unlockFilter(payload)) {
+				continue
+			}
+			return false, nil
+		case <-time.After(dl.retryDelay(i, ttl)):
+			success, ttl, err = dl.tryAcquire(blockCtx, &l)
+			if err == nil && success {
+				dl.acquired(l)
+				return true, nil
+			}
+		}
+	}
+	return false, fmt.Errorf("%w: %q", ErrLockTimeout, dl.key)
+}
@@ -0,0 +1,195 @@
+package pslock
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// OpStats describes a completed Lock or Unlock for an Observer.
+type OpStats struct {
+	// Op is "lock" or "unlock".
+	Op  string
+	Key string
+	// Commands is the number of Redis commands the operation issued,
+	// pipelined commands counted one by one. Pub/sub subscriptions are
+	// not counted.
+	Commands int64
+	Elapsed  time.Duration
+	Err      error
+	// Phases is the time a lock spent in each acquisition phase, summed
+	// over its attempts. Phases absent from the map were not entered. It
+	// is nil for unlocks.
+	Phases map[Phase]time.Duration
+	// Held is how long the lock was held when Unlock was called, from
+	// its acquisition; with the Elapsed of the lock, which covers the
+	// wait, it spans the lifecycle of a hold. It is 0 for locks and for
+	// unlocks of a lock that was not held.
+	Held time.Duration
+	// Winner identifies the mutex that acquired a lock after waiting for
+	// a holder, e.g. to compute the distribution of wins per owner under
+	// contention. It is the owner ID of WithOwnerID, or else the name of
+	// WithName, or else an ID drawn when the mutex was created, so that
+	// the default mutexes of a key still tell their wins apart; it is
+	// never the random token. It is empty for unlocks, failed locks and
+	// locks acquired on the first attempt.
+	Winner string
+}
+
+// A Phase labels a part of the acquisition in OpStats.Phases.
+type Phase string
+
+const (
+	// PhaseFastPath is the time spent in direct acquisition attempts,
+	// the first one and those following an unlock message.
+	PhaseFastPath Phase = "fast_path"
+	// PhaseSubscribe is the time spent setting up the unlock
+	// subscription, including the check of the lock once it is confirmed.
+	PhaseSubscribe Phase = "subscribe"
+	// PhasePoll is the time spent in polling attempts while waiting. It
+	// overlaps with PhaseWait, as the polling runs alongside.
+	PhasePoll Phase = "poll"
+	// PhaseWait is the time spent blocked waiting for an unlock message,
+	// a successful poll or a handoff.
+	PhaseWait Phase = "wait"
+)
+
+// An Observer is notified of every Lock and Unlock of a mutex, e.g. to
+// quantify the cost of contention. ObserveOp is called synchronously at
+// the end of the operation and should return quickly.
+type Observer interface {
+	ObserveOp(stats OpStats)
+}
+
+type commandCounterKey struct{}
+
+// withCommandCounter returns a context whose Redis commands are counted in
+// counter.
+func withCommandCounter(ctx context.Context, counter *atomic.Int64) context.Context {
+	return context.WithValue(ctx, commandCounterKey{}, counter)
+}
+
+// commandCounter is a Redis hook that counts commands sent with a context
+// from withCommandCounter.
+type commandCounter struct{}
+
+func (commandCounter) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (commandCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if c, ok := ctx.Value(commandCounterKey{}).(*atomic.Int64); ok {
+			c.Add(1)
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (commandCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if c, ok := ctx.Value(commandCounterKey{}).(*atomic.Int64); ok {
+			c.Add(int64(len(cmds)))
+		}
+		return next(ctx, cmds)
+	}
+}
+
+type phaseTimerKey struct{}
+
+// phaseTimer sums the time spent in each phase of a lock.
+type phaseTimer struct {
+	mu     sync.Mutex
+	phases map[Phase]time.Duration
+}
+
+// startPhase starts timing phase p if ctx carries a phase timer, and
+// returns a func that stops it. Without an observer it costs a context
+// lookup only.
+func startPhase(ctx context.Context, p Phase) func() {
+	t, ok := ctx.Value(phaseTimerKey{}).(*phaseTimer)
+	if !ok {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		d := time.Since(start)
+		t.mu.Lock()
+		t.phases[p] += d
+		t.mu.Unlock()
+	}
+}
+
+// instrument adds the command counter hook to c once.
+func (r *PSLock) instrument(c *redis.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.instrumented[c]; ok {
+		return
+	}
+	r.instrumented[c] = struct{}{}
+	c.AddHook(commandCounter{})
+}
+
+// observe returns a context counting the commands of op and a func that
+// reports op to the observer when called with its result.
+func (dl *Mutex) observe(ctx context.Context, op string) (context.Context, func(err error)) {
+	counter := new(atomic.Int64)
+	start := time.Now()
+	ctx = withCommandCounter(ctx, counter)
+	var timer *phaseTimer
+	var trace *lockTrace
+	var held time.Duration
+	switch op {
+	case "lock":
+		timer = &phaseTimer{phases: make(map[Phase]time.Duration)}
+		ctx = context.WithValue(ctx, phaseTimerKey{}, timer)
+		// Share the trace of LockWithResult, if any.
+		var ok bool
+		if trace, ok = ctx.Value(lockTraceKey{}).(*lockTrace); !ok {
+			trace = &lockTrace{}
+			ctx = context.WithValue(ctx, lockTraceKey{}, trace)
+		}
+	case "unlock":
+		dl.mu.Lock()
+		if !dl.acquiredAt.IsZero() {
+			held = start.Sub(dl.acquiredAt)
+		}
+		dl.mu.Unlock()
+	}
+	return ctx, func(err error) {
+		stats := OpStats{
+			Op:       op,
+			Key:      dl.key,
+			Commands: counter.Load(),
+			Elapsed:  time.Since(start),
+			Err:      err,
+			Held:     held,
+		}
+		if timer != nil {
+			// A polling attempt may still finish after the lock returned.
+			timer.mu.Lock()
+			stats.Phases = maps.Clone(timer.phases)
+			timer.mu.Unlock()
+		}
+		if trace != nil && trace.contended.Load() && err == nil {
+			stats.Winner = dl.winner()
+		}
+		dl.observer.ObserveOp(stats)
+	}
+}
+
+// winner returns the identity of dl reported in OpStats.Winner.
+func (dl *Mutex) winner() string {
+	switch {
+	case dl.ownerID != "":
+		return dl.ownerID
+	case dl.name != dl.key:
+		return dl.name
+	}
+	return dl.instanceID
+}
@@ -0,0 +1,105 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrOpTimeout is returned when a single Redis operation of a mutex did not
+// complete within the op timeout. Unlike ErrLockTimeout, it does not mean
+// that the lock is held by someone else.
+var ErrOpTimeout = errors.New("redis operation timeout")
+
+// withOpTimeout runs op with a context bounded by the op timeout of dl, if
+// any. On timeout it returns ErrOpTimeout right away and abandons op with
+// its context cancelled, since the client may not observe the context.
+func withOpTimeout[T any](ctx context.Context, dl *Mutex, op func(ctx context.Context) (T, error)) (T, error) {
+	if dl.opTimeout <= 0 {
+		return op(ctx)
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, dl.opTimeout)
+	defer cancel()
+
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := op(opCtx)
+		done <- result{v, err}
+	}()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-opCtx.Done():
+		var zero T
+		if err := ctx.Err(); err != nil {
+			return zero, err
+		}
+		return zero, ErrOpTimeout
+	}
+}
+
+// doWithOpTimeout is withOpTimeout for operations without a result.
+func doWithOpTimeout(ctx context.Context, dl *Mutex, op func(ctx context.Context) error) error {
+	_, err := withOpTimeout(ctx, dl, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, op(ctx)
+	})
+	return err
+}
+
+// A pinnedConn is a connection of its own for a series of operations,
+// e.g. the polling attempts of WithDedicatedConn. An operation given up on
+// by withPinnedOpTimeout may still be using the connection, so the
+// connection is retired with it: it is closed once the operation returns,
+// and the following operations get a fresh one. A pinnedConn is not safe
+// for concurrent use.
+type pinnedConn struct {
+	client *redis.Client
+	conn   *redis.Conn
+	// The running operations on conn
+	ops *sync.WaitGroup
+}
+
+func pinConn(c *redis.Client) *pinnedConn {
+	return &pinnedConn{client: c, conn: c.Conn(), ops: new(sync.WaitGroup)}
+}
+
+// retire closes the connection once its operations returned and moves on
+// to a fresh one.
+func (p *pinnedConn) retire() {
+	p.Close()
+	p.conn, p.ops = p.client.Conn(), new(sync.WaitGroup)
+}
+
+// Close closes the connection once its operations returned.
+func (p *pinnedConn) Close() {
+	conn, ops := p.conn, p.ops
+	go func() {
+		ops.Wait()
+		conn.Close()
+	}()
+}
+
+// withPinnedOpTimeout is withOpTimeout for op on the connection of p. If
+// op is abandoned, the connection is retired.
+func withPinnedOpTimeout[T any](ctx context.Context, dl *Mutex, p *pinnedConn, op func(ctx context.Context, c redis.Cmdable) (T, error)) (T, error) {
+	conn, ops := p.conn, p.ops
+	var returned atomic.Bool
+	ops.Add(1)
+	v, err := withOpTimeout(ctx, dl, func(ctx context.Context) (T, error) {
+		defer ops.Done()
+		defer returned.Store(true)
+		return op(ctx, conn)
+	})
+	if !returned.Load() {
+		p.retire()
+	}
+	return v, err
+}
@@ -0,0 +1,117 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// errVersionMoved aborts the WATCH transaction when the version differs.
+var errVersionMoved = errors.New("version moved")
+
+// Optimistic is a non-blocking lock that is only granted while a version
+// key still holds the expected value. It shares the key space of Mutex,
+// so an Optimistic and a Mutex with the same key exclude each other.
+type Optimistic struct {
+	pslock      *PSLock
+	client      *redis.Client
+	key         string
+	versionKey  string
+	expiry      time.Duration
+	notifier    Notifier
+	keyEncoding KeyEncoding
+
+	value string
+}
+
+// NewOptimistic returns a new optimistic lock with given key guarded by
+// versionKey. Only the expiry, client, notifier and key encoding options
+// apply to it; the version key is used as is.
+func (r *PSLock) NewOptimistic(key, versionKey string, options ...Option) *Optimistic {
+	m := r.NewMutex(key, options...)
+	return &Optimistic{
+		pslock:      r,
+		client:      m.client,
+		key:         key,
+		versionKey:  versionKey,
+		expiry:      m.expiry,
+		notifier:    m.getNotifier(),
+		keyEncoding: m.keyEncoding,
+	}
+}
+
+func (o *Optimistic) getKey() string {
+	return lockPrefix + o.keyEncoding.encode(o.key)
+}
+
+// TryAcquire acquires the lock if it is free and the version key holds
+// expectedVersion, a missing version key counting as 0. It never blocks.
+//
+// The version key is WATCHed while the lock is set in MULTI/EXEC. If the
+// version changes between the check and EXEC, the transaction aborts and
+// TryAcquire returns false without retrying: the caller is expected to
+// re-read the version and decide whether to try again.
+func (o *Optimistic) TryAcquire(ctx context.Context, expectedVersion int64) (bool, error) {
+	if o.pslock.draining.Load() {
+		return false, ErrDraining
+	}
+
+	value, err := genToken()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %q: %w", o.key, err)
+	}
+
+	var setCmd *redis.BoolCmd
+	err = o.client.Watch(ctx, func(tx *redis.Tx) error {
+		version, err := tx.Get(ctx, o.versionKey).Int64()
+		if err == redis.Nil {
+			version = 0
+		} else if err != nil {
+			return err
+		}
+		if version != expectedVersion {
+			return errVersionMoved
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			setCmd = pipe.SetNX(ctx, o.getKey(), value, o.expiry)
+			return nil
+		})
+		return err
+	}, o.versionKey)
+
+	if err == errVersionMoved || err == redis.TxFailedErr {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %q: %w", o.key, err)
+	}
+	if !setCmd.Val() {
+		return false, nil
+	}
+	o.value = value
+	return true, nil
+}
+
+// Release releases the lock if it is still held by this Optimistic and
+// notifies waiting mutexes on the same key.
+func (o *Optimistic) Release(ctx context.Context) error {
+	lockKey := o.getKey()
+
+	n, err := unlockScript.Run(ctx, o.client, []string{lockKey}, o.value).Int()
+	if err != nil {
+		return fmt.Errorf("failed to release lock %q: %w", o.key, err)
+	}
+	o.value = ""
+	if n == 0 {
+		return fmt.Errorf("%w: %q", ErrLockNotHeld, o.key)
+	}
+
+	if err := o.notifier.Publish(ctx, lockKey, unlockPayload); err != nil {
+		return fmt.Errorf("failed to publish unlock message for lock %q: %w", o.key, err)
+	}
+	return nil
+}
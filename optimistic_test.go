@@ -0,0 +1,38 @@
+package pslock
+
+import (
+	"context"
+	"testing"
+)
+
+func TestOptimistic_TryAcquireChecksVersion(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	versionKey := "test-optimistic-version"
+	client.Del(ctx, versionKey)
+
+	lock := r.NewOptimistic("test-optimistic", versionKey)
+	ok, err := lock.TryAcquire(ctx, 0)
+	if err != nil || !ok {
+		t.Fatalf("expected acquire at version 0, got %v, %v", ok, err)
+	}
+
+	other := r.NewOptimistic("test-optimistic", versionKey)
+	if ok, _ := other.TryAcquire(ctx, 0); ok {
+		t.Error("expected acquire to fail while the lock is held")
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+
+	client.Incr(ctx, versionKey)
+	if ok, _ := other.TryAcquire(ctx, 0); ok {
+		t.Error("expected acquire to fail after the version moved")
+	}
+	ok, err = other.TryAcquire(ctx, 1)
+	if err != nil || !ok {
+		t.Fatalf("expected acquire at version 1, got %v, %v", ok, err)
+	}
+	other.Release(ctx)
+}
@@ -0,0 +1,60 @@
+package pslock
+
+import (
+	"bytes"
+	"runtime"
+	"slices"
+	"strconv"
+)
+
+// goroutineID returns the ID of the calling goroutine, parsed from the
+// header of its stack trace. It is only meant for development aids.
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// checkOrder logs a warning if the calling goroutine holds a ranked lock
+// whose rank is not lower than the rank of dl.
+func (dl *Mutex) checkOrder() {
+	gid := goroutineID()
+
+	dl.pslock.mu.Lock()
+	defer dl.pslock.mu.Unlock()
+	for _, m := range dl.pslock.ranked[gid] {
+		if m.orderRank >= dl.orderRank {
+			dl.logger.Printf("pslock: lock order inversion: acquiring %q (rank %d) while holding %q (rank %d)",
+				dl.name, dl.orderRank, m.name, m.orderRank)
+		}
+	}
+}
+
+// holdRanked records that the calling goroutine holds dl.
+func (dl *Mutex) holdRanked() {
+	gid := goroutineID()
+
+	dl.pslock.mu.Lock()
+	defer dl.pslock.mu.Unlock()
+	dl.rankGoroutine = gid
+	dl.pslock.ranked[gid] = append(dl.pslock.ranked[gid], dl)
+}
+
+// releaseRanked removes dl from the locks held by the goroutine that
+// acquired it.
+func (dl *Mutex) releaseRanked() {
+	dl.pslock.mu.Lock()
+	defer dl.pslock.mu.Unlock()
+	gid := dl.rankGoroutine
+	held := slices.DeleteFunc(dl.pslock.ranked[gid], func(m *Mutex) bool { return m == dl })
+	if len(held) == 0 {
+		delete(dl.pslock.ranked, gid)
+	} else {
+		dl.pslock.ranked[gid] = held
+	}
+}
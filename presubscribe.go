@@ -0,0 +1,85 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// presubConns is the number of pub/sub connections per client that carry
+// the persistent subscriptions of PreSubscribe.
+const presubConns = 1
+
+// PreSubscribe establishes persistent subscriptions to the channels of
+// the locks with given keys, e.g. for a few very hot keys, so that
+// blocked Locks of mutexes using the default pub/sub on the same client
+// join them instead of subscribing anew each time. The subscriptions are
+// multiplexed over a single connection per client and shared safely by
+// concurrent waiters. They last until Unsubscribe or Close. Mutexes with
+// WithKeyEncoding do not join them, as their channels are named after the
+// encoded key.
+func (r *PSLock) PreSubscribe(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		channel := lockPrefix + key
+		r.mu.Lock()
+		_, ok := r.presubscribed[channel]
+		r.mu.Unlock()
+		if ok {
+			continue
+		}
+
+		sub, err := r.subscriptionMux(r.clientFor(key), presubConns).Subscribe(ctx, channel)
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to lock %q: %w", key, err)
+		}
+		r.mu.Lock()
+		if _, ok := r.presubscribed[channel]; ok {
+			// Subscribed concurrently
+			r.mu.Unlock()
+			sub.Close()
+			continue
+		}
+		r.presubscribed[channel] = sub
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+// Unsubscribe tears down the persistent subscriptions of PreSubscribe for
+// the locks with given keys. Waiters already subscribed keep their
+// subscription until they stop waiting.
+func (r *PSLock) Unsubscribe(keys ...string) error {
+	var errs []error
+	for _, key := range keys {
+		channel := lockPrefix + key
+		r.mu.Lock()
+		sub, ok := r.presubscribed[channel]
+		delete(r.presubscribed, channel)
+		r.mu.Unlock()
+		if ok {
+			errs = append(errs, sub.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// presubNotifier is the default Notifier of a mutex. Subscriptions to a
+// channel with a persistent subscription join it on the shared
+// connection; the others go to the embedded Notifier.
+type presubNotifier struct {
+	Notifier
+	pslock *PSLock
+	client *redis.Client
+}
+
+func (n presubNotifier) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	n.pslock.mu.Lock()
+	_, ok := n.pslock.presubscribed[channel]
+	n.pslock.mu.Unlock()
+	if ok {
+		return n.pslock.subscriptionMux(n.client, presubConns).Subscribe(ctx, channel)
+	}
+	return n.Notifier.Subscribe(ctx, channel)
+}
@@ -0,0 +1,60 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// provisionalLease marks the acquisition of AcquireProvisional, carried by
+// its ctx, and records the expiry the hold is committed to.
+type provisionalLease struct {
+	committed time.Duration
+}
+
+type provisionalKey struct{}
+
+// AcquireProvisional acquires the lock like Lock, but with the short
+// expiry of WithProvisionalExpiry, for flows that check whether the work
+// is still wanted before they commit to it. commit extends the hold to the
+// full expiry, and abort releases it like Unlock, so that a rejected job
+// holds the lock only briefly. Both return ErrLockNotHeld once the
+// provisional hold expired or was released, and only one of them should
+// be called. With WithAutoRenew the watchdog keeps the hold alive with the
+// provisional expiry until commit.
+func (dl *Mutex) AcquireProvisional(ctx context.Context) (commit func(context.Context) error, abort func(context.Context) error, err error) {
+	if ctx == nil {
+		return nil, nil, fmt.Errorf("%w: %q", ErrNilContext, dl.key)
+	}
+	p := &provisionalLease{}
+	if err := dl.Lock(context.WithValue(ctx, provisionalKey{}, p)); err != nil {
+		return nil, nil, err
+	}
+	dl.mu.Lock()
+	value := dl.value
+	dl.mu.Unlock()
+
+	commit = func(ctx context.Context) error {
+		if ctx == nil {
+			return fmt.Errorf("%w: %q", ErrNilContext, dl.key)
+		}
+		dl.mu.Lock()
+		if dl.value == value {
+			// Extend to the expiry picked at acquisition.
+			dl.heldExpiry = p.committed
+			dl.heldProvisional = false
+		}
+		dl.mu.Unlock()
+		return dl.extend(ctx, value)
+	}
+	abort = func(ctx context.Context) error {
+		dl.mu.Lock()
+		held := dl.value == value
+		dl.mu.Unlock()
+		if !held {
+			return fmt.Errorf("%w: %q", ErrLockNotHeld, dl.key)
+		}
+		return dl.Unlock(ctx)
+	}
+	return commit, abort, nil
+}
@@ -2,7 +2,13 @@ package pslock
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -13,9 +19,41 @@ const (
 	maxRetryDelayMilliSec = 250
 )
 
+// ErrDraining is returned by acquisitions on a PSLock after Drain or Close.
+var ErrDraining = errors.New("pslock is draining")
+
 // Redsync provides a simple method for creating distributed mutexes using multiple Redis connection pools.
 type PSLock struct {
 	client *redis.Client
+	// The instances locks are spread over by key, if set by NewSpread
+	spread []*redis.Client
+
+	draining atomic.Bool
+	// Closed by Close to stop background goroutines
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu sync.Mutex
+	// Mutexes currently held through this instance
+	held map[*Mutex]struct{}
+	// Held mutexes by the value they wrote
+	tokens map[string]*Mutex
+	// Process-local locks of LocalMutex by key
+	local map[string]*localLock
+	// Ranked mutexes held by goroutine ID, for lock order checks
+	ranked map[uint64][]*Mutex
+	// Clients that count commands for observers
+	instrumented map[*redis.Client]struct{}
+	// Whether the function library is loaded, by client
+	functions map[*redis.Client]bool
+	// Shared subscriptions of WithMaxSubscriptions
+	muxes map[muxKey]*subscriptionMux
+	// Cached flags of WithEnabledKey by client and key
+	flags map[flagCacheKey]flagState
+	// Persistent subscriptions of PreSubscribe by channel
+	presubscribed map[string]Subscription
+	// The queues of the event sinks of WithEventSink
+	sinks map[any]*sinkQueue
 }
 
 // New creates and returns a new Redsync instance from given Redis connection pools.
@@ -25,27 +63,220 @@ func New(c *redis.Client) *PSLock {
 		panic(cmd.Err())
 	}
 	return &PSLock{
-		client: c,
+		client:        c,
+		closed:        make(chan struct{}),
+		held:          make(map[*Mutex]struct{}),
+		tokens:        make(map[string]*Mutex),
+		local:         make(map[string]*localLock),
+		ranked:        make(map[uint64][]*Mutex),
+		instrumented:  make(map[*redis.Client]struct{}),
+		functions:     make(map[*redis.Client]bool),
+		muxes:         make(map[muxKey]*subscriptionMux),
+		flags:         make(map[flagCacheKey]flagState),
+		presubscribed: make(map[string]Subscription),
+		sinks:         make(map[any]*sinkQueue),
+	}
+}
+
+// Drain makes every subsequent acquisition through this instance fail
+// with ErrDraining without touching Redis. Locks that are already held
+// can still be unlocked.
+func (r *PSLock) Drain() {
+	r.draining.Store(true)
+}
+
+// A CloseOption configures Close.
+type CloseOption func(*closeConfig)
+
+type closeConfig struct {
+	releaseCtx  context.Context
+	closeClient bool
+}
+
+// CloseReleasingLocks makes Close unlock all held locks with ctx before
+// stopping, instead of leaving them held until they expire.
+func CloseReleasingLocks(ctx context.Context) CloseOption {
+	return func(c *closeConfig) {
+		c.releaseCtx = ctx
+	}
+}
+
+// CloseClient makes Close also close the Redis clients passed to New or
+// NewSpread.
+func CloseClient() CloseOption {
+	return func(c *closeConfig) {
+		c.closeClient = true
+	}
+}
+
+// Close drains the instance and stops all background activity started
+// through it: the max hold reapers, expiry warnings and renewal watchdogs
+// of held locks, the subscriptions of Watch and OnExpired, and the shared
+// connections of WithMaxSubscriptions and PreSubscribe. Held locks stay
+// held until they are unlocked or expire, unless CloseReleasingLocks is
+// given. The Redis client is owned by the caller and left open, unless
+// CloseClient is given. Close is safe to call more than once; only the
+// first call has an effect.
+func (r *PSLock) Close(opts ...CloseOption) error {
+	var err error
+	r.closeOnce.Do(func() {
+		var cfg closeConfig
+		for _, o := range opts {
+			o(&cfg)
+		}
+
+		r.Drain()
+		if cfg.releaseCtx != nil {
+			err = r.ReleaseAll(cfg.releaseCtx)
+		}
+		for _, m := range r.HeldLocks() {
+			m.stopReaper()
+		}
+		r.mu.Lock()
+		for _, m := range r.muxes {
+			m.close()
+		}
+		clear(r.presubscribed)
+		r.mu.Unlock()
+		close(r.closed)
+		if cfg.closeClient {
+			for _, c := range r.clients() {
+				err = errors.Join(err, c.Close())
+			}
+		}
+	})
+	return err
+}
+
+// HeldLocks returns the mutexes that currently hold their lock through this
+// instance, as far as known locally. A lock that expired in Redis is still
+// listed until it is unlocked.
+func (r *PSLock) HeldLocks() []*Mutex {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	held := make([]*Mutex, 0, len(r.held))
+	for m := range r.held {
+		held = append(held, m)
+	}
+	return held
+}
+
+// RenewAll extends every held lock like Extend. It tries all of them and
+// returns the joined errors of those that could not be extended.
+func (r *PSLock) RenewAll(ctx context.Context) error {
+	var errs []error
+	for _, m := range r.HeldLocks() {
+		if err := m.Extend(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReleaseAll unlocks every held lock, e.g. on graceful shutdown. It tries
+// all of them and returns the joined errors of those that failed.
+func (r *PSLock) ReleaseAll(ctx context.Context) error {
+	var errs []error
+	for _, m := range r.HeldLocks() {
+		if err := m.Unlock(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ForceUnlock deletes the lock with given key regardless of its holder and
+// notifies waiters. It is meant for operators clearing a stuck lock; the
+// previous holder is not told and may still act as if it held the lock.
+// ErrLockNotHeld is returned if the lock was not held. The options of the
+// mutexes using the lock, such as WithKeyEncoding, locate it.
+func (r *PSLock) ForceUnlock(ctx context.Context, key string, options ...Option) error {
+	client, encoded := r.locate(key, options)
+	lockKey := lockPrefix + encoded
+	defaultLogger.Printf("pslock: WARNING force unlocking lock %q regardless of its holder", key)
+
+	n, err := client.Del(ctx, lockKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to force unlock lock %q: %w", key, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %q", ErrLockNotHeld, key)
+	}
+	r.emitAll(LockEvent{Key: key, Event: EventForceUnlocked, Time: time.Now()})
+	if err := client.Publish(ctx, lockKey, unlockPayload).Err(); err != nil {
+		return fmt.Errorf("failed to publish unlock message for lock %q: %w", key, err)
+	}
+	return nil
+}
+
+func (r *PSLock) track(m *Mutex, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.held[m] = struct{}{}
+	r.tokens[value] = m
+}
+
+func (r *PSLock) untrack(m *Mutex, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.held, m)
+	if r.tokens[value] == m {
+		delete(r.tokens, value)
 	}
 }
 
+// retrack moves the token of m from old to value after a rotation.
+func (r *PSLock) retrack(m *Mutex, old, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tokens[old] == m {
+		delete(r.tokens, old)
+	}
+	if _, ok := r.held[m]; ok {
+		r.tokens[value] = m
+	}
+}
+
+// holder returns the held mutex that wrote value, or nil.
+func (r *PSLock) holder(value string) *Mutex {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tokens[value]
+}
+
 // NewMutex returns a new distributed mutex with given name.
-func (r PSLock) NewMutex(key string, options ...Option) *Mutex {
+func (r *PSLock) NewMutex(key string, options ...Option) *Mutex {
 
 	m := &Mutex{
-		client:  r.client,
-		key:     key,
-		name:    key,
-		expiry:  8 * time.Second,
-		patient: 8 * time.Second,
-		tries:   32,
-		delayFunc: func(tries int) time.Duration {
-			return time.Duration(rand.Intn(maxRetryDelayMilliSec-minRetryDelayMilliSec)+minRetryDelayMilliSec) * time.Millisecond
-		},
+		pslock:        r,
+		key:           key,
+		name:          key,
+		expiry:        8 * time.Second,
+		patient:       8 * time.Second,
+		tries:         32,
+		rand:          newRand(),
+		logger:        defaultLogger,
+		unlockTimeout: 5 * time.Second,
+		provisional:   time.Second,
+	}
+	m.instanceID = fmt.Sprintf("%s#%016x", key, m.rand.Uint64())
+	m.delayFunc = func(tries int) time.Duration {
+		return time.Duration(m.rand.Intn(maxRetryDelayMilliSec-minRetryDelayMilliSec)+minRetryDelayMilliSec) * time.Millisecond
 	}
 	for _, o := range options {
 		o.Apply(m)
 	}
+	m.options = options
+	r.route(m)
+	if m.maxSubscriptions > 0 && m.notifier == nil {
+		m.notifier = r.subscriptionMux(m.client, m.maxSubscriptions)
+	}
+	if m.observer != nil {
+		r.instrument(m.client)
+	}
+	if m.eventSink != nil {
+		m.sinkQueue = r.sinkQueue(m.eventSinkKey, m.eventSink)
+	}
 	return m
 }
 
@@ -79,7 +310,7 @@ func WithExpiry(expiry time.Duration) Option {
 }
 
 // WithTries can be used to set the number of times lock acquire is attempted.
-// The default value is 32.
+// Lock returns ErrLockTimeout once they are exhausted. The default value is 32.
 func WithTries(tries int) Option {
 	return OptionFunc(func(m *Mutex) {
 		m.tries = tries
@@ -102,3 +333,538 @@ func WithRetryDelayFunc(delayFunc DelayFunc) Option {
 		m.delayFunc = delayFunc
 	})
 }
+
+// WithLogger can be used to set the logger for problems reported in the
+// background. The default is the standard logger of the log package.
+func WithLogger(logger Logger) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.logger = logger
+	})
+}
+
+// WithSlog can be used to log structured records of every Lock and Unlock
+// to l, with the key, the number of tries, the elapsed time and the
+// outcome as attributes. Successes are logged at debug level and failures
+// as warnings. Problems reported in the background go to l as warnings
+// too, replacing the logger set with WithLogger.
+func WithSlog(l *slog.Logger) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.slog = l
+		m.logger = slogLogger{l}
+	})
+}
+
+// WithObserver can be used to report every Lock and Unlock to o, with the
+// number of Redis commands it issued. Counting adds a cheap hook to the
+// mutex client. The default reports nothing.
+func WithObserver(o Observer) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.observer = o
+	})
+}
+
+// WithMaxHold can be used to force-release a lock that has not been
+// unlocked within d after acquisition. This is enforced locally and is
+// independent of the expiry in Redis. The default is no limit.
+func WithMaxHold(d time.Duration) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.maxHold = d
+	})
+}
+
+// WithOnMaxHold can be used to set a callback invoked right before a lock
+// is force-released because it exceeded the max hold duration.
+func WithOnMaxHold(fn func(m *Mutex)) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.onMaxHold = fn
+	})
+}
+
+// WithExpiryWarning can be used to log a warning when a lock is still held
+// locally after its expiry lapsed, i.e. the lock in Redis was silently lost
+// and another holder may exist. The lost callback is invoked as well.
+func WithExpiryWarning() Option {
+	return OptionFunc(func(m *Mutex) {
+		m.expiryWarn = true
+	})
+}
+
+// WithAutoRenew can be used to keep a held lock alive by extending it every
+// third of the expiry until Unlock. A failed extension is retried with
+// backoff while the lock is still alive in Redis. Once the lock is gone or
+// its expiry passed, it is reported lost: the context returned by
+// LockWithRelease is cancelled and the lost callback is invoked. The
+// default is no renewal.
+func WithAutoRenew() Option {
+	return OptionFunc(func(m *Mutex) {
+		m.autoRenew = true
+	})
+}
+
+// WithClockSkew can be used to take a safety margin of d for clock skew
+// between the application and Redis off the remaining lease: Until and
+// TTL report it d shorter, Valid reports the lock unsafe d before its raw
+// expiry, and the renewal watchdog of WithAutoRenew times its extensions
+// as if the expiry were d shorter. A larger margin makes fencing decisions
+// more conservative at the cost of more frequent renewals. It panics if d
+// is negative. The default is no margin.
+func WithClockSkew(d time.Duration) Option {
+	if d < 0 {
+		panic("pslock: WithClockSkew needs a non-negative margin")
+	}
+	return OptionFunc(func(m *Mutex) {
+		m.clockSkew = d
+	})
+}
+
+// WithAutoUnlockOnCancel can be used for request-scoped locks: once the
+// context passed to a successful Lock is done, the lock is released as by
+// UnlockDefer, without a deferred Unlock. An Unlock before that stops the
+// automatic release, so the lock is never released twice. The default
+// keeps the lock until Unlock or expiry.
+func WithAutoUnlockOnCancel() Option {
+	return OptionFunc(func(m *Mutex) {
+		m.autoUnlockOnCancel = true
+	})
+}
+
+// WithProvisionalExpiry can be used to set the expiry of provisional holds
+// of AcquireProvisional until they are committed. It is capped at the
+// expiry of the mutex and panics if not positive. The default is 1 second.
+func WithProvisionalExpiry(expiry time.Duration) Option {
+	if expiry <= 0 {
+		panic("pslock: WithProvisionalExpiry needs a positive expiry")
+	}
+	return OptionFunc(func(m *Mutex) {
+		m.provisional = expiry
+	})
+}
+
+// WithIdleTimeout can be used with WithAutoRenew for holders such as
+// interactive sessions that may be abandoned: once the holder went d
+// without calling Touch since the acquisition, the watchdog stops renewing
+// and the lock expires in Redis within the mutex expiry. The default
+// renews until Unlock.
+func WithIdleTimeout(d time.Duration) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.idleTimeout = d
+	})
+}
+
+// WithOnLost can be used to set a callback invoked once per hold when the
+// mutex detects that the lock was lost, with an error describing how.
+func WithOnLost(fn func(ctx context.Context, m *Mutex, err error)) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.onLost = fn
+	})
+}
+
+// WithUnlockMessageFilter can be used to make waiters react only to
+// messages on the lock channel for which filter returns true, ignoring
+// unrelated traffic. The default treats every message as an unlock.
+func WithUnlockMessageFilter(filter func(payload string) bool) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.unlockFilter = filter
+	})
+}
+
+// WithClient can be used to run all operations of a mutex, including the
+// pub/sub wait in the blocking flow, on c instead of the PSLock client.
+// Redis selects the logical DB per connection, so this is how lock keys
+// are kept in a dedicated DB: pass a client created with that DB. Note
+// that pub/sub channels are not scoped to a DB in Redis, so waiters on
+// equal keys in different DBs may wake each other up spuriously.
+func WithClient(c *redis.Client) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.client = c
+	})
+}
+
+// WithTransientRetries can be used to retry an acquisition attempt up to n
+// times with backoff when Redis returns a transient error, e.g. MOVED during
+// resharding or a timeout. Other errors still fail fast. The default is 0.
+func WithTransientRetries(n int) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.transientRetries = n
+	})
+}
+
+// WithStrictPublish can be used to make Unlock return an error when the
+// lock was released but the unlock notification could not be published.
+// By default such an error is only logged, since waiters still acquire
+// the released lock by polling.
+func WithStrictPublish(strict bool) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.strictPublish = strict
+	})
+}
+
+// WithPublishRetries can be used to retry a failed publish of the unlock
+// notification up to n times with a short backoff, so that waiters are
+// woken up promptly despite a brief pub/sub failure instead of only by
+// their next poll. The release itself is never retried. The retries count
+// against the unlock timeout. The default is 0.
+func WithPublishRetries(n int) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.publishRetries = n
+	})
+}
+
+// WithOwnerID can be used to write a stable owner identity as the lock
+// value instead of a random token per acquisition. Lock then resumes a
+// lock already held under the same ID, refreshing its TTL, rather than
+// waiting for it, e.g. when a crashed workflow step is retried. Holders
+// sharing an ID are not protected from each other's Unlock. The ID must
+// not start with "steal:", the mark of WithStealable.
+func WithOwnerID(id string) Option {
+	if strings.HasPrefix(id, stealablePrefix) {
+		panic("pslock: WithOwnerID needs an ID not starting with " + stealablePrefix)
+	}
+	return OptionFunc(func(m *Mutex) {
+		m.ownerID = id
+	})
+}
+
+// WithAdaptiveRetryDelay can be used to wait between retries until the
+// current holder's lease is about to run out, as reported by Redis on the
+// failed attempt, capped at max. This replaces the retry delay while the
+// holder's TTL is known. The default uses the retry delay only.
+func WithAdaptiveRetryDelay(max time.Duration) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.adaptiveDelay = max
+	})
+}
+
+// WithMinRetryDelay can be used to set a floor on the delay between
+// retries, whatever the retry delay, WithRetryDelayFunc or
+// WithAdaptiveRetryDelay computed, e.g. so that holders' leases that are
+// about to run out do not make waiters hammer Redis. The default has no
+// floor.
+func WithMinRetryDelay(d time.Duration) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.minRetryDelay = d
+	})
+}
+
+// WithAdaptiveExpiry can be used to pick the expiry of each acquisition
+// between min and max from the RTT to Redis, measured with a PING before
+// the attempt: the slower Redis responds, the longer the lease, so that
+// renewals are less frequent when each one is costly. The expiry spans
+// 1000 round trips, clamped to the bounds, and is logged when it changes.
+// Extensions keep the expiry picked at acquisition. It replaces the
+// expiry and its jitter, and costs one round trip per Lock, whose retries
+// keep the expiry of the first attempt. It needs 0 < min <= max.
+func WithAdaptiveExpiry(min, max time.Duration) Option {
+	if min <= 0 || min > max {
+		panic("pslock: WithAdaptiveExpiry needs 0 < min <= max")
+	}
+	return OptionFunc(func(m *Mutex) {
+		m.adaptiveExpiryMin, m.adaptiveExpiryMax = min, max
+	})
+}
+
+// WithAcquireEvents can be used to publish an acquired event on the lock
+// channel whenever the mutex obtains the lock after waiting for it, so
+// that Watch consumers see contended keys being taken. Waiters ignore
+// these events. The default publishes release events only.
+func WithAcquireEvents() Option {
+	return OptionFunc(func(m *Mutex) {
+		m.acquireEvents = true
+	})
+}
+
+// WithExpiryJitter can be used to perturb the expiry of each acquisition
+// randomly by up to ±fraction, e.g. 0.1 for ±10%, so that locks acquired
+// together do not expire together. The fraction must be at least 0 and
+// below 1, so that every expiry stays positive. The default is no jitter.
+func WithExpiryJitter(fraction float64) Option {
+	if fraction < 0 || fraction >= 1 {
+		panic("pslock: WithExpiryJitter needs a fraction in [0, 1)")
+	}
+	return OptionFunc(func(m *Mutex) {
+		m.expiryJitter = fraction
+	})
+}
+
+// WithDeadlockWarn can be used during development to log the key and the
+// caller's stack when Lock has not completed within d, which often points
+// to a deadlock. The default is disabled, and then costs nothing.
+func WithDeadlockWarn(d time.Duration) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.deadlockWarn = d
+	})
+}
+
+// WithOrderRank can be used during development to log a warning when a
+// goroutine locks this mutex while holding a ranked lock of equal or
+// higher rank, i.e. acquires locks out of order, which may deadlock. It
+// does not prevent the acquisition. The default is unranked.
+func WithOrderRank(rank int) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.ranked = true
+		m.orderRank = rank
+	})
+}
+
+// WithCircuitBreaker can be used to make Lock fail right away with
+// ErrCircuitOpen for cooldown after failures consecutive Lock calls failed
+// because of Redis errors, so that a dead Redis is not hammered. After the
+// cooldown a single trial Lock is let through, which closes the breaker on
+// success or opens it again on failure. A busy lock does not count as a
+// failure. The default has no breaker.
+func WithCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.breaker = &breaker{failures: failures, cooldown: cooldown}
+	})
+}
+
+// WithRedisFunctions can be used to run the ownership-checked Unlock,
+// Extend and TTL as Redis Functions called with FCALL instead of EVAL
+// scripts, for deployments that manage server-side code as function
+// libraries. The "pslock" library is loaded with FUNCTION LOAD REPLACE on
+// first use per client. Servers without FUNCTION support, before Redis 7
+// or with FUNCTION denied, fall back to EVAL. The default uses EVAL.
+func WithRedisFunctions() Option {
+	return OptionFunc(func(m *Mutex) {
+		m.functions = true
+	})
+}
+
+// WithEnabledKey can be used to gate the locking behind a flag at flagKey
+// in Redis, e.g. to roll out locking without a redeploy. While the flag
+// holds "0" or "false", Lock returns nil right away without taking the
+// lock, and the matching Unlock does nothing. Any other value, a missing
+// key or a failure to read it enforces the lock. The flag is cached for
+// a second, so a change takes up to that long to apply.
+//
+// A disabled flag turns off mutual exclusion: every caller proceeds as if
+// it held the lock, and callers that locked before a change of the flag
+// overlap with those that lock after it. TryLock and the other ways of
+// acquiring are not gated.
+func WithEnabledKey(flagKey string) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.enabledKey = flagKey
+	})
+}
+
+// WithRandSource can be used to draw the default retry delays and the
+// expiry jitter of the mutex from src, e.g. a seeded source for
+// reproducible timing in tests. Mutexes sharing src, including clones,
+// draw from it in turn. By default each mutex has a generator of its own,
+// seeded at construction, so that mutexes do not contend on a shared one.
+func WithRandSource(src rand.Source) Option {
+	r := rand.New(&lockedSource{src: src})
+	return OptionFunc(func(m *Mutex) {
+		m.rand = r
+	})
+}
+
+// WithTokenGenerator can be used to create the token of each acquisition
+// with gen instead of crypto/rand, e.g. to derive it from a vault or embed
+// signed claims. An error from gen aborts the acquisition. Tokens must be
+// unique per acquisition and as hard to guess as the default ones, since
+// they are all that the ownership checks compare. WithOwnerID takes
+// precedence.
+func WithTokenGenerator(gen func() (string, error)) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.tokenGen = gen
+	})
+}
+
+// WithDedicatedConn can be used to run the polling attempts of a blocked
+// Lock on a connection taken from the pool for the duration of the wait,
+// so that they do not queue behind other command traffic on a busy pool.
+// The connection is returned when the wait ends, on acquisition, timeout
+// or cancellation. An attempt given up on after WithOpTimeout takes the
+// connection with it, and the next attempt takes a fresh one. The pub/sub
+// subscription always has a connection of its own. The default uses the
+// pool for every attempt.
+func WithDedicatedConn() Option {
+	return OptionFunc(func(m *Mutex) {
+		m.dedicatedConn = true
+	})
+}
+
+// WithSafetyPoll can be used to retry the acquisition every interval while
+// waiting, as a safety net for missed pub/sub messages that does not
+// depend on the tries or the retry delay. An unlock published before the
+// unlock subscription is set up is covered without it, as the lock is
+// checked again once the subscription is confirmed. The default relies on
+// the regular retries only.
+func WithSafetyPoll(interval time.Duration) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.safetyPoll = interval
+	})
+}
+
+// WithExpiredEvents can be used to wake up waiters when the lock expires
+// in Redis, which publishes no unlock message, instead of at their next
+// retry: each blocked Lock also subscribes to the expired events of the
+// database and retries once the lock key expires. This needs keyspace
+// notifications for expired events, see OnExpired; without them waiters
+// just rely on their retries. It costs a pub/sub connection per waiter
+// and receives the expired events of all keys in the database.
+func WithExpiredEvents() Option {
+	return OptionFunc(func(m *Mutex) {
+		m.expiredEvents = true
+	})
+}
+
+// WithRetryDecider can be used to decide before each retry of a blocked
+// Lock whether to keep waiting, e.g. based on a feature flag or a budget.
+// decide gets the number of the retry, starting at 1, and the time since
+// the wait began; returning false makes Lock fail with ErrRetryAborted.
+// The tries and the patient still bound the wait. The default always
+// retries.
+func WithRetryDecider(decide func(ctx context.Context, try int, elapsed time.Duration) bool) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.retryDecider = decide
+	})
+}
+
+// WithMaxWaiters can be used to make Lock fail right away with
+// ErrTooManyWaiters instead of waiting when n or more mutexes already wait
+// for the lock, so that callers can shed load on a hot key. The default
+// waits regardless of the number of waiters.
+func WithMaxWaiters(n int) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.maxWaiters = n
+	})
+}
+
+// WithDetectSelfLock can be used to make Lock fail with ErrSelfLock instead
+// of waiting when the lock is held by a mutex created from the same PSLock,
+// which usually is an accidental double acquisition. The default waits.
+func WithDetectSelfLock() Option {
+	return OptionFunc(func(m *Mutex) {
+		m.detectSelfLock = true
+	})
+}
+
+// WithHandoff can be used to wait for the lock by blocking on a Redis list
+// with BRPOP instead of pub/sub with polling. Unlock pushes one token per
+// release, so a single waiter is woken up instead of all of them. All
+// mutexes on the key must use this option for Unlock to push the tokens.
+// Each waiter occupies a pooled connection while it blocks.
+func WithHandoff() Option {
+	return OptionFunc(func(m *Mutex) {
+		m.handoff = true
+	})
+}
+
+// WithContextMetadata can be used to embed the values stored under keys in
+// the context passed to Lock in the lock value, e.g. trace or request IDs,
+// to be read with PSLock.Metadata. The value becomes a JSON object holding
+// the token and the metadata, see WithValueCodec. With WithOwnerID, a lock
+// is only resumed if the metadata is unchanged. The default writes the
+// bare token.
+func WithContextMetadata(keys ...any) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.metadataKeys = keys
+	})
+}
+
+// WithValueCodec can be used to replace the encoding of the lock value of
+// WithContextMetadata, e.g. with BinaryCodec to keep values small on hot
+// keys. Mutexes sharing a lock with WithOwnerID must use the same codec.
+// The default is JSONCodec.
+func WithValueCodec(c ValueCodec) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.valueCodec = c
+	})
+}
+
+// WithHistory can be used to record the acquisitions and releases of the
+// lock in a Redis list capped at the n most recent events, to be read with
+// PSLock.History. It costs a round trip per event. The default records
+// nothing.
+func WithHistory(n int) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.historyLen = n
+	})
+}
+
+// WithKeyEncoding can be used to encode the key before it is used in Redis
+// keys and pub/sub channels, e.g. with HexKeyEncoding for keys containing
+// glob characters, spaces or arbitrary bytes. PSLock methods that take a
+// key, such as ForceUnlock or Watch, take the plain key and the encoding
+// among their options. The default uses the key as is.
+func WithKeyEncoding(enc KeyEncoding) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.keyEncoding = enc
+	})
+}
+
+// WithMaxSubscriptions can be used to share at most n pub/sub connections
+// among the waiters of all mutexes of the PSLock created with the same n
+// on the same client, instead of one connection per blocked Lock. Each
+// contended key is subscribed once, on the connection picked by its hash,
+// and unlock messages are demultiplexed to its waiters. This bounds the
+// connections used under many distinct contended keys. It has no effect
+// together with WithNotifier.
+func WithMaxSubscriptions(n int) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.maxSubscriptions = n
+	})
+}
+
+// WithNotifier can be used to replace Redis pub/sub as the mechanism that
+// wakes up waiters on Unlock, e.g. with PollOnlyNotifier on backends
+// without pub/sub. The default is pub/sub on the mutex client.
+func WithNotifier(n Notifier) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.notifier = n
+	})
+}
+
+// WithOpTimeout can be used to bound each single Redis operation of the
+// mutex, such as an acquisition attempt, an extension, the release or the
+// unlock notification, so that one slow operation does not use up the
+// patient or stall the renewal watchdog.
+// A timed out operation fails with ErrOpTimeout, which the blocking flow
+// and the transient retries treat like any other retryable error. The
+// default is no bound besides the context.
+func WithOpTimeout(d time.Duration) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.opTimeout = d
+	})
+}
+
+// WithUnlockTimeout can be used to set the maximum time Unlock waits for
+// Redis, regardless of the context passed to it. A d <= 0 leaves Unlock
+// bounded by its context only. The default is 5s.
+func WithUnlockTimeout(d time.Duration) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.unlockTimeout = d
+	})
+}
+
+// WithAuxTTL can be used to set the TTL of the auxiliary keys of the lock,
+// the waiter counter, the handoff list and the history list, so that the
+// coordination data of crashed waiters and idle locks is removed after d.
+// Each use of a key refreshes its TTL, so d should cover the longest wait.
+// By default the waiter counter lives twice the patient, the handoff list
+// as long as the lease and the history list has no TTL. Intent markers
+// always expire with the child lease.
+func WithAuxTTL(d time.Duration) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.auxTTL = d
+	})
+}
+
+// WithMaxReplicationLag can be used for critical locks that must not be
+// lost in a failover: after each successful acquisition attempt the mutex
+// reads the number of connected replicas with INFO replication and waits
+// up to d with WAIT for all of them to acknowledge the write. If they do
+// not, or the check fails, the acquisition is undone. Lock then fails with
+// ErrReplicationLag on its first attempt, while later attempts of a
+// blocked Lock count as failed and are retried. Each acquisition costs two
+// extra round trips and, under lag, up to d. Servers without replicas are
+// not checked. A lock resumed with WithOwnerID is kept if the check
+// fails. TryLockWithHolder, LockIf and StealLock do not check
+// replication. The default does not check replication.
+func WithMaxReplicationLag(d time.Duration) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.maxReplicationLag = d
+	})
+}
@@ -2,8 +2,16 @@ package pslock
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"math/rand"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -170,3 +178,4300 @@ func loopworker(suc *int) time.Duration {
 	}
 	return waitTime
 }
+
+func TestMutex_MaxHoldForceReleases(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+
+	fired := make(chan struct{})
+	mutex := r.NewMutex("test-mutex-max-hold",
+		WithMaxHold(100*time.Millisecond),
+		WithOnMaxHold(func(m *Mutex) {
+			close(fired)
+		}),
+	)
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("failed to acquire lock: %v", err)
+	}
+
+	select {
+	case <-fired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected max hold callback to fire")
+	}
+
+	// The force release runs right after the callback.
+	time.Sleep(50 * time.Millisecond)
+	n, err := client.Exists(ctx, mutex.getKey()).Result()
+	if err != nil {
+		t.Fatalf("exists failed: %v", err)
+	}
+	if n != 0 {
+		t.Error("expected lock key to be deleted after max hold")
+	}
+}
+
+func TestMutex_UnlockMessageFilter(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-unlock-filter"
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+
+	waiter := r.NewMutex(name,
+		WithRetryDelay(5*time.Second),
+		WithUnlockMessageFilter(func(payload string) bool {
+			return payload == "unlock"
+		}),
+	)
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// Free the key without a recognized unlock message.
+	client.Del(ctx, holder.getKey())
+	client.Publish(ctx, holder.getKey(), "noise")
+	select {
+	case err := <-done:
+		t.Fatalf("expected waiter to ignore unrelated message, got %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	client.Publish(ctx, holder.getKey(), "unlock")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waiter failed to acquire lock: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected waiter to wake on unlock message")
+	}
+	waiter.Unlock(ctx)
+}
+
+func TestMutex_LockWithRelease(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+
+	mutex := r.NewMutex("test-mutex-with-release")
+	lockCtx, release, err := mutex.LockWithRelease(ctx)
+	if err != nil {
+		t.Fatalf("failed to acquire lock: %v", err)
+	}
+	if lockCtx.Err() != nil {
+		t.Fatal("expected lock context to be live while held")
+	}
+
+	release()
+	if lockCtx.Err() == nil {
+		t.Error("expected lock context to be cancelled after release")
+	}
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 0 {
+		t.Error("expected lock key to be deleted after release")
+	}
+	// A second release is a no-op.
+	release()
+}
+
+func TestMutex_LockWithReleaseCancelsOnExpiry(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+
+	mutex := r.NewMutex("test-mutex-with-release-expiry", WithExpiry(100*time.Millisecond))
+	lockCtx, release, err := mutex.LockWithRelease(ctx)
+	if err != nil {
+		t.Fatalf("failed to acquire lock: %v", err)
+	}
+	defer release()
+
+	select {
+	case <-lockCtx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected lock context to be cancelled when the expiry lapses")
+	}
+}
+
+func TestMutex_DuplicateUnlockAfterReacquire(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-duplicate-unlock"
+
+	first := r.NewMutex(name)
+	if err := first.Lock(ctx); err != nil {
+		t.Fatalf("first lock failed: %v", err)
+	}
+	firstValue := first.value
+	if err := first.Unlock(ctx); err != nil {
+		t.Fatalf("first unlock failed: %v", err)
+	}
+
+	second := r.NewMutex(name)
+	if err := second.Lock(ctx); err != nil {
+		t.Fatalf("second lock failed: %v", err)
+	}
+	defer second.Unlock(ctx)
+	if second.value == firstValue {
+		t.Fatal("expected a fresh value per acquisition")
+	}
+
+	// A retried Unlock from the first holder must not release the second.
+	if err := first.Unlock(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+	got, err := client.Get(ctx, second.getKey()).Result()
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if got != second.value {
+		t.Errorf("expected lock to still hold second value, got %q", got)
+	}
+}
+
+func TestMutex_StaleUnlockAfterExpiry(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-stale-unlock"
+
+	first := r.NewMutex(name, WithExpiry(100*time.Millisecond))
+	if err := first.Lock(ctx); err != nil {
+		t.Fatalf("first lock failed: %v", err)
+	}
+
+	second := r.NewMutex(name, WithRetryDelay(20*time.Millisecond))
+	if err := second.Lock(ctx); err != nil {
+		t.Fatalf("second lock failed: %v", err)
+	}
+	defer second.Unlock(ctx)
+
+	if err := first.Unlock(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+	if n, _ := client.Exists(ctx, second.getKey()).Result(); n != 1 {
+		t.Error("expected second holder to keep the lock")
+	}
+}
+
+func TestMutex_ErrorsIncludeKey(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-error-key"
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	waiter := r.NewMutex(name, WithRetryDelay(20*time.Millisecond))
+	shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	err := waiter.Lock(shortCtx)
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+	if !strings.Contains(err.Error(), name) {
+		t.Errorf("expected lock key in error, got %q", err)
+	}
+
+	err = waiter.Unlock(ctx)
+	if !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("expected ErrLockNotHeld, got %v", err)
+	}
+	if !strings.Contains(err.Error(), name) {
+		t.Errorf("expected lock key in error, got %q", err)
+	}
+}
+
+func TestPSLock_DrainRejectsNewLocks(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+
+	held := r.NewMutex("test-pslock-drain-held")
+	if err := held.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+
+	r.Drain()
+	if err := r.NewMutex("test-pslock-drain-new").Lock(ctx); !errors.Is(err, ErrDraining) {
+		t.Errorf("expected ErrDraining, got %v", err)
+	}
+	if err := held.Unlock(ctx); err != nil {
+		t.Errorf("expected held lock to unlock while draining, got %v", err)
+	}
+}
+
+func TestPSLock_CloseStopsReapers(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+
+	mutex := r.NewMutex("test-pslock-close", WithMaxHold(100*time.Millisecond))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 1 {
+		t.Error("expected max hold reaper to be stopped by Close")
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Errorf("expected unlock after Close, got %v", err)
+	}
+}
+
+func TestPSLock_CloseReleasingLocks(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-pslock-close-release"
+
+	mutex := r.NewMutex(name, WithAutoRenew())
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	events, err := r.Watch(ctx, "test-pslock-close-watch")
+	if err != nil {
+		t.Fatalf("watch failed: %v", err)
+	}
+
+	if err := r.Close(CloseReleasingLocks(ctx)); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 0 {
+		t.Error("expected held lock to be released by Close")
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("expected no events after Close")
+		}
+	case <-time.After(time.Second):
+		t.Error("expected Watch channel to be closed by Close")
+	}
+
+	if err := r.Close(CloseReleasingLocks(ctx)); err != nil {
+		t.Errorf("expected a second Close to be a no-op, got %v", err)
+	}
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Errorf("expected the client to stay open, got %v", err)
+	}
+}
+
+func TestMutex_WithClientUsesSelectedDB(t *testing.T) {
+	dataClient := mockRedisClient()
+	lockClient := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+		DB:   1,
+	})
+	defer lockClient.Close()
+	r := New(dataClient)
+	ctx := context.Background()
+	name := "test-mutex-with-client"
+
+	holder := r.NewMutex(name, WithClient(lockClient))
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if n, _ := lockClient.Exists(ctx, holder.getKey()).Result(); n != 1 {
+		t.Error("expected lock key in the selected DB")
+	}
+	if n, _ := dataClient.Exists(ctx, holder.getKey()).Result(); n != 0 {
+		t.Error("expected no lock key in the default DB")
+	}
+
+	// The waiter subscribes through the same client as its SetNX.
+	waiter := r.NewMutex(name, WithClient(lockClient), WithRetryDelay(5*time.Second))
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	holder.Unlock(ctx)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waiter failed to acquire lock: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected waiter to be woken by unlock in the selected DB")
+	}
+	waiter.Unlock(ctx)
+}
+
+// redisErr is a Redis protocol error returned by failingHook.
+type redisErr string
+
+func (e redisErr) Error() string { return string(e) }
+
+func (redisErr) RedisError() {}
+
+// failingHook fails the first n commands with the given name. With
+// applied, the commands run before failing, as if their reply was lost.
+type failingHook struct {
+	mu      sync.Mutex
+	cmd     string
+	n       int
+	err     error
+	applied bool
+	calls   int
+}
+
+func (h *failingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *failingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == h.cmd {
+			h.mu.Lock()
+			h.calls++
+			fail := h.calls <= h.n
+			h.mu.Unlock()
+			if fail {
+				if h.applied {
+					next(ctx, cmd)
+				}
+				cmd.SetErr(h.err)
+				return h.err
+			}
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *failingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestMutex_TransientRetries(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	client.AddHook(&failingHook{cmd: "set", n: 2, err: redisErr("TRYAGAIN multiple keys request during rehashing of slot")})
+
+	mutex := r.NewMutex("test-mutex-transient", WithTransientRetries(3))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("expected lock after transient errors, got %v", err)
+	}
+	mutex.Unlock(ctx)
+
+	// The retry of an acquisition whose reply was lost finds the lock it
+	// took.
+	lost := mockRedisClient()
+	lost.AddHook(&failingHook{cmd: "set", n: 1, err: io.EOF, applied: true})
+	name := "test-mutex-transient-lost-reply"
+	client.Del(ctx, lockPrefix+name)
+	retried := r.NewMutex(name, WithClient(lost), WithTransientRetries(1))
+	retried.patient = 500 * time.Millisecond
+	if err := retried.Lock(ctx); err != nil {
+		t.Fatalf("expected the retry to find the lock acquired, got %v", err)
+	}
+	if err := retried.Unlock(ctx); err != nil {
+		t.Errorf("unlock failed: %v", err)
+	}
+}
+
+func TestMutex_PermanentErrorFailsFast(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	hook := &failingHook{cmd: "set", n: 1, err: redisErr("WRONGTYPE Operation against a key holding the wrong kind of value")}
+	client.AddHook(hook)
+
+	mutex := r.NewMutex("test-mutex-permanent", WithTransientRetries(3))
+	if err := mutex.Lock(ctx); err == nil {
+		t.Fatal("expected permanent error to fail the lock")
+	}
+	if hook.calls != 1 {
+		t.Errorf("expected a single attempt, got %d", hook.calls)
+	}
+}
+
+func TestPSLock_WaiterCount(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-pslock-waiter-count"
+	client.Del(ctx, waitersKey(name))
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancelled := make(chan error, 1)
+	done := make(chan error, 1)
+	go func() {
+		cancelled <- r.NewMutex(name, WithRetryDelay(5*time.Second)).Lock(cancelCtx)
+	}()
+	waiter := r.NewMutex(name, WithRetryDelay(5*time.Second))
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	if n, err := r.WaiterCount(ctx, name); err != nil || n != 2 {
+		t.Fatalf("expected 2 waiters, got %d, %v", n, err)
+	}
+
+	cancel()
+	<-cancelled
+	if n, _ := r.WaiterCount(ctx, name); n != 1 {
+		t.Errorf("expected 1 waiter after cancellation, got %d", n)
+	}
+
+	holder.Unlock(ctx)
+	if err := <-done; err != nil {
+		t.Fatalf("waiter failed to acquire lock: %v", err)
+	}
+	defer waiter.Unlock(ctx)
+	if n, _ := r.WaiterCount(ctx, name); n != 0 {
+		t.Errorf("expected no waiters after acquisition, got %d", n)
+	}
+}
+
+func TestMutex_UnlockPublishFailure(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	client.AddHook(&failingHook{cmd: "publish", n: 1, err: errors.New("connection reset")})
+
+	mutex := r.NewMutex("test-mutex-publish-failure")
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Errorf("expected publish failure to be non-fatal, got %v", err)
+	}
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 0 {
+		t.Error("expected lock key to be deleted")
+	}
+
+	client.AddHook(&failingHook{cmd: "publish", n: 1, err: errors.New("connection reset")})
+	strict := r.NewMutex("test-mutex-publish-failure", WithStrictPublish(true))
+	if err := strict.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if err := strict.Unlock(ctx); err == nil {
+		t.Error("expected strict publish to return the publish error")
+	}
+	if n, _ := client.Exists(ctx, strict.getKey()).Result(); n != 0 {
+		t.Error("expected lock key to be deleted")
+	}
+}
+
+func TestMutex_OwnerIDResumesHold(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-owner-id"
+
+	crashed := r.NewMutex(name, WithOwnerID("step-42"), WithExpiry(time.Second))
+	if err := crashed.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+
+	// The retried step resumes the hold at once and refreshes its TTL.
+	resumed := r.NewMutex(name, WithOwnerID("step-42"), WithExpiry(8*time.Second), WithTries(1))
+	if err := resumed.Lock(ctx); err != nil {
+		t.Fatalf("expected owner to resume the lock, got %v", err)
+	}
+	if ttl, _ := client.PTTL(ctx, resumed.getKey()).Result(); ttl <= time.Second {
+		t.Errorf("expected TTL to be refreshed, got %v", ttl)
+	}
+
+	other := r.NewMutex(name, WithOwnerID("step-43"), WithRetryDelay(20*time.Millisecond))
+	shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := other.Lock(shortCtx); err == nil {
+		t.Error("expected a different owner to wait for the lock")
+	}
+	if err := resumed.Unlock(ctx); err != nil {
+		t.Errorf("unlock failed: %v", err)
+	}
+}
+
+// countingHook counts the commands sent through a client.
+type countingHook struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (h *countingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *countingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.mu.Lock()
+		h.count++
+		h.mu.Unlock()
+		return next(ctx, cmd)
+	}
+}
+
+func (h *countingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func (h *countingHook) Count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func TestMutex_AdaptiveRetryDelay(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-adaptive-delay"
+
+	holder := r.NewMutex(name, WithExpiry(200*time.Millisecond))
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+
+	waiter := r.NewMutex(name, WithAdaptiveRetryDelay(time.Second))
+	start := time.Now()
+	if err := waiter.Lock(ctx); err != nil {
+		t.Fatalf("waiter failed to acquire lock: %v", err)
+	}
+	defer waiter.Unlock(ctx)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected waiter to wake around the holder's expiry, took %v", elapsed)
+	}
+}
+
+// benchmarkExpiryWait measures the Redis commands a waiter issues while
+// waiting for a holder that never unlocks but expires.
+func benchmarkExpiryWait(b *testing.B, options ...Option) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "bench-mutex-expiry-wait"
+	hook := &countingHook{}
+	waitClient := mockRedisClient()
+	waitClient.AddHook(hook)
+
+	for range b.N {
+		holder := r.NewMutex(name, WithExpiry(300*time.Millisecond))
+		if err := holder.Lock(ctx); err != nil {
+			b.Fatalf("holder failed to acquire lock: %v", err)
+		}
+		waiter := r.NewMutex(name, append(options, WithClient(waitClient))...)
+		if err := waiter.Lock(ctx); err != nil {
+			b.Fatalf("waiter failed to acquire lock: %v", err)
+		}
+		waiter.Unlock(ctx)
+	}
+	b.ReportMetric(float64(hook.Count())/float64(b.N), "cmds/op")
+}
+
+func BenchmarkMutex_FixedRetryDelay(b *testing.B) {
+	benchmarkExpiryWait(b)
+}
+
+func BenchmarkMutex_AdaptiveRetryDelay(b *testing.B) {
+	benchmarkExpiryWait(b, WithAdaptiveRetryDelay(time.Second))
+}
+
+func TestPSLock_WatchAcquiredAndReleased(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	name := "test-pslock-watch"
+
+	events, err := r.Watch(ctx, name)
+	if err != nil {
+		t.Fatalf("watch failed: %v", err)
+	}
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	waiter := r.NewMutex(name, WithName("waiter"), WithAcquireEvents(), WithRetryDelay(5*time.Second))
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	holder.Unlock(ctx)
+	if err := <-done; err != nil {
+		t.Fatalf("waiter failed to acquire lock: %v", err)
+	}
+
+	want := []LockEvent{
+		{Key: name, Event: EventReleased},
+		{Key: name, Event: EventAcquired, Holder: "waiter"},
+	}
+	for _, w := range want {
+		select {
+		case got := <-events:
+			if got != w {
+				t.Errorf("expected event %+v, got %+v", w, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected event %+v", w)
+		}
+	}
+	waiter.Unlock(ctx)
+}
+
+func TestMutex_CloneAndWithKey(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+
+	base := r.NewMutex("test-mutex-clone", WithExpiry(3*time.Second), WithTries(5))
+	other := base.WithKey("test-mutex-clone-other")
+	if other.key != "test-mutex-clone-other" || other.name != "test-mutex-clone-other" {
+		t.Errorf("expected key and name of the new key, got %q, %q", other.key, other.name)
+	}
+	if other.expiry != 3*time.Second || other.tries != 5 {
+		t.Errorf("expected options to be copied, got expiry %v, tries %d", other.expiry, other.tries)
+	}
+
+	clone := base.Clone(WithTries(7))
+	if clone.key != base.key || clone.expiry != 3*time.Second || clone.tries != 7 {
+		t.Errorf("expected clone with overridden tries, got key %q, expiry %v, tries %d", clone.key, clone.expiry, clone.tries)
+	}
+	if base.tries != 5 {
+		t.Errorf("expected base to be unchanged, got tries %d", base.tries)
+	}
+
+	if err := other.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer other.Unlock(ctx)
+	if base.value != "" || clone.value != "" {
+		t.Error("expected clones not to share hold state")
+	}
+}
+
+func TestMutex_AddToPipe(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+
+	mutex := r.NewMutex("test-mutex-pipe")
+	other := r.NewMutex("test-mutex-pipe")
+	pipe := client.Pipeline()
+	acquired := mutex.AddToPipe(pipe)
+	contended := other.AddToPipe(pipe)
+	incr := pipe.Incr(ctx, "test-mutex-pipe-counter")
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+
+	if !acquired.Val() {
+		t.Fatal("expected the first queued acquisition to succeed")
+	}
+	if contended.Val() {
+		t.Error("expected the second queued acquisition to fail")
+	}
+	if incr.Err() != nil {
+		t.Errorf("expected pipelined work to run, got %v", incr.Err())
+	}
+	if ok, err := mutex.Valid(ctx); err != nil || !ok {
+		t.Errorf("expected the pipelined lock to be valid, got %v, %v", ok, err)
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Errorf("unlock failed: %v", err)
+	}
+}
+
+func TestMutex_ExpiryJitter(t *testing.T) {
+	r := New(mockRedisClient())
+
+	plain := r.NewMutex("test-mutex-jitter")
+	if got := plain.jitteredExpiry(); got != 8*time.Second {
+		t.Errorf("expected no jitter by default, got %v", got)
+	}
+
+	mutex := r.NewMutex("test-mutex-jitter", WithExpiry(10*time.Second), WithExpiryJitter(0.1))
+	seen := make(map[time.Duration]bool)
+	for range 100 {
+		got := mutex.jitteredExpiry()
+		if got < 9*time.Second || got > 11*time.Second {
+			t.Fatalf("expected expiry within ±10%%, got %v", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Error("expected jittered expiries to differ")
+	}
+
+	for _, fraction := range []float64{-0.1, 1, 1.5} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a panic for jitter fraction %v", fraction)
+				}
+			}()
+			WithExpiryJitter(fraction)
+		}()
+	}
+}
+
+func TestMutex_TTL(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+
+	mutex := r.NewMutex("test-mutex-ttl", WithExpiry(2*time.Second))
+	if _, err := mutex.TTL(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld before Lock, got %v", err)
+	}
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	ttl, err := mutex.TTL(ctx)
+	if err != nil {
+		t.Fatalf("ttl failed: %v", err)
+	}
+	if ttl <= time.Second || ttl > 2*time.Second {
+		t.Errorf("expected TTL close to 2s, got %v", ttl)
+	}
+
+	client.Persist(ctx, mutex.getKey())
+	if _, err := mutex.TTL(ctx); !errors.Is(err, ErrNoExpiry) {
+		t.Errorf("expected ErrNoExpiry, got %v", err)
+	}
+	mutex.Unlock(ctx)
+}
+
+// bufferLogger collects log output for assertions.
+type bufferLogger struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (l *bufferLogger) Printf(format string, v ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(&l.buf, format+"\n", v...)
+}
+
+func (l *bufferLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+func TestMutex_DeadlockWarn(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-deadlock-warn"
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	logger := &bufferLogger{}
+	waiter := r.NewMutex(name, WithLogger(logger), WithDeadlockWarn(50*time.Millisecond), WithRetryDelay(20*time.Millisecond))
+	shortCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	waiter.Lock(shortCtx)
+
+	out := logger.String()
+	if !strings.Contains(out, name) || !strings.Contains(out, "goroutine") {
+		t.Errorf("expected warning with key and stack, got %q", out)
+	}
+}
+
+// recordingNotifier records published payloads and delivers nothing.
+type recordingNotifier struct {
+	PollOnlyNotifier
+	mu        sync.Mutex
+	published []string
+}
+
+func (n *recordingNotifier) Publish(ctx context.Context, channel, payload string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.published = append(n.published, payload)
+	return nil
+}
+
+// brokenNotifier fails every subscription.
+type brokenNotifier struct {
+	PollOnlyNotifier
+}
+
+func (brokenNotifier) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	return nil, errors.New("notifier unavailable")
+}
+
+func TestMutex_SubscribeFailure(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-subscribe-failure"
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	logger := &bufferLogger{}
+	waiter := r.NewMutex(name, WithNotifier(brokenNotifier{}), WithLogger(logger))
+	if err := waiter.Lock(ctx); err == nil || !strings.Contains(err.Error(), "notifier unavailable") {
+		t.Fatalf("expected the subscription error, got %v", err)
+	}
+	if !strings.Contains(logger.String(), "failed to subscribe") {
+		t.Errorf("expected the failure to be logged, got %q", logger.String())
+	}
+}
+
+func TestMutex_PollOnlyNotifier(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-poll-only"
+
+	notifier := &recordingNotifier{}
+	holder := r.NewMutex(name, WithNotifier(notifier))
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+
+	waiter := r.NewMutex(name, WithNotifier(PollOnlyNotifier{}), WithRetryDelay(20*time.Millisecond))
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := holder.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waiter failed to acquire lock: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected waiter to acquire the lock by polling")
+	}
+	waiter.Unlock(ctx)
+
+	if len(notifier.published) != 1 || notifier.published[0] != unlockPayload {
+		t.Errorf("expected unlock to publish through the notifier, got %v", notifier.published)
+	}
+}
+
+// stallingHook blocks commands with the given name for delay, ignoring
+// their context, like a hung Redis.
+type stallingHook struct {
+	cmd   string
+	delay time.Duration
+}
+
+func (h *stallingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *stallingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == h.cmd {
+			time.Sleep(h.delay)
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *stallingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			if cmd.Name() == h.cmd {
+				time.Sleep(h.delay)
+				break
+			}
+		}
+		return next(ctx, cmds)
+	}
+}
+
+// lateReplyHook runs the first n commands with the given name but delays
+// their reply, like a Redis whose answers are slow to arrive.
+type lateReplyHook struct {
+	mu    sync.Mutex
+	cmd   string
+	n     int
+	delay time.Duration
+}
+
+func (h *lateReplyHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *lateReplyHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		h.mu.Lock()
+		late := cmd.Name() == h.cmd && h.n > 0
+		if late {
+			h.n--
+		}
+		h.mu.Unlock()
+		if late {
+			time.Sleep(h.delay)
+		}
+		return err
+	}
+}
+
+func (h *lateReplyHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestMutex_UnlockTimeout(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+
+	mutex := r.NewMutex("test-mutex-unlock-timeout", WithUnlockTimeout(100*time.Millisecond))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	client.AddHook(&stallingHook{cmd: "evalsha", delay: time.Second})
+
+	start := time.Now()
+	err := mutex.Unlock(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected Unlock to return after the timeout, took %v", elapsed)
+	}
+	if mutex.value != "" {
+		t.Error("expected the lock to be released locally")
+	}
+
+	// Without a timeout, Unlock waits for Redis.
+	unbounded := r.NewMutex("test-mutex-unlock-no-timeout", WithUnlockTimeout(0))
+	client.Del(ctx, unbounded.getKey())
+	if err := unbounded.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if err := unbounded.Unlock(ctx); err != nil {
+		t.Errorf("expected Unlock without a timeout to succeed, got %v", err)
+	}
+}
+
+func TestMutex_IntentLocks(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	parent := "test-mutex-intent-parent"
+	client.Del(ctx, intentKey(parent))
+
+	child := r.NewMutex("test-mutex-intent-child", WithIntentParent(parent))
+	if err := child.Lock(ctx); err != nil {
+		t.Fatalf("child lock failed: %v", err)
+	}
+
+	writer := r.NewMutex(parent, WithIntentCheck(), WithRetryDelay(20*time.Millisecond))
+	shortCtx, cancel := context.WithTimeout(ctx, 150*time.Millisecond)
+	defer cancel()
+	if err := writer.Lock(shortCtx); err == nil {
+		t.Fatal("expected parent writer to wait while a child holds an intent")
+	}
+
+	if err := child.Unlock(ctx); err != nil {
+		t.Fatalf("child unlock failed: %v", err)
+	}
+	if err := writer.Lock(ctx); err != nil {
+		t.Fatalf("expected parent writer to acquire after the child released, got %v", err)
+	}
+
+	// While the parent is write-locked, children cannot be locked.
+	other := r.NewMutex("test-mutex-intent-child-2", WithIntentParent(parent), WithRetryDelay(20*time.Millisecond))
+	shortCtx2, cancel2 := context.WithTimeout(ctx, 150*time.Millisecond)
+	defer cancel2()
+	if err := other.Lock(shortCtx2); err == nil {
+		t.Error("expected child lock to wait while the parent is write-locked")
+	}
+	writer.Unlock(ctx)
+
+	// An extension of the child renews its intent as well.
+	short := r.NewMutex("test-mutex-intent-child-3", WithIntentParent(parent), WithExpiry(300*time.Millisecond))
+	if err := short.Lock(ctx); err != nil {
+		t.Fatalf("child lock failed: %v", err)
+	}
+	defer short.Unlock(ctx)
+	time.Sleep(200 * time.Millisecond)
+	if err := short.Extend(ctx); err != nil {
+		t.Fatalf("extend failed: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	shortCtx3, cancel3 := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel3()
+	if err := writer.Lock(shortCtx3); err == nil {
+		writer.Unlock(ctx)
+		t.Error("expected the extended intent to outlive the original lease")
+	}
+}
+
+func TestMutex_ExpiryWarning(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-expiry-warning"
+
+	lost := make(chan error, 1)
+	logger := &bufferLogger{}
+	mutex := r.NewMutex(name,
+		WithExpiry(50*time.Millisecond),
+		WithLogger(logger),
+		WithExpiryWarning(),
+		WithOnLost(func(ctx context.Context, m *Mutex, err error) {
+			lost <- err
+		}),
+	)
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+
+	select {
+	case err := <-lost:
+		if !errors.Is(err, ErrLockExpired) {
+			t.Errorf("expected ErrLockExpired, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected lost callback after the expiry lapsed")
+	}
+	if !strings.Contains(logger.String(), name) {
+		t.Errorf("expected warning naming the lock, got %q", logger.String())
+	}
+
+	// A lock unlocked in time is not reported.
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	mutex.Unlock(ctx)
+	select {
+	case err := <-lost:
+		t.Errorf("unexpected lost callback after unlock: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMutex_OrderRank(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+
+	logger := &bufferLogger{}
+	first := r.NewMutex("test-mutex-order-1", WithOrderRank(1), WithLogger(logger))
+	second := r.NewMutex("test-mutex-order-2", WithOrderRank(2), WithLogger(logger))
+
+	first.Lock(ctx)
+	second.Lock(ctx)
+	second.Unlock(ctx)
+	first.Unlock(ctx)
+	if out := logger.String(); out != "" {
+		t.Errorf("expected no warning for ordered acquisition, got %q", out)
+	}
+
+	second.Lock(ctx)
+	first.Lock(ctx)
+	first.Unlock(ctx)
+	second.Unlock(ctx)
+	if out := logger.String(); !strings.Contains(out, "inversion") {
+		t.Errorf("expected lock order inversion warning, got %q", out)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.ranked) != 0 {
+		t.Errorf("expected held ranks to be cleaned up, got %v", r.ranked)
+	}
+}
+
+func TestPSLock_ForceUnlock(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-pslock-force-unlock"
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+
+	waiter := r.NewMutex(name, WithRetryDelay(time.Second))
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	if err := r.ForceUnlock(ctx, name); err != nil {
+		t.Fatalf("force unlock failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waiter failed to acquire lock: %v", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("expected waiter to be woken up by the force unlock")
+	}
+
+	if err := holder.Unlock(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld for the previous holder, got %v", err)
+	}
+	waiter.Unlock(ctx)
+	if err := r.ForceUnlock(ctx, name); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld for a free lock, got %v", err)
+	}
+}
+
+func TestMutex_AutoRenew(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-auto-renew"
+
+	var mu sync.Mutex
+	var lostErrs []error
+	mutex := r.NewMutex(name,
+		WithExpiry(150*time.Millisecond),
+		WithAutoRenew(),
+		WithLogger(&bufferLogger{}),
+		WithOnLost(func(ctx context.Context, m *Mutex, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			lostErrs = append(lostErrs, err)
+		}),
+	)
+	lockCtx, release, err := mutex.LockWithRelease(ctx)
+	if err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer release()
+
+	time.Sleep(400 * time.Millisecond)
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 1 {
+		t.Fatal("expected the lock to be renewed past its expiry")
+	}
+	if lockCtx.Err() != nil {
+		t.Fatal("expected the hold context to stay alive while renewed")
+	}
+
+	// Delete the key behind the holder's back.
+	client.Del(ctx, mutex.getKey())
+	select {
+	case <-lockCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the hold context to be cancelled once the lock is lost")
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(lostErrs) != 1 {
+		t.Fatalf("expected lost callback exactly once, got %d", len(lostErrs))
+	}
+	if !errors.Is(lostErrs[0], ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld, got %v", lostErrs[0])
+	}
+}
+
+func TestMutex_DetectSelfLock(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-detect-self-lock"
+
+	first := r.NewMutex(name)
+	if err := first.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+
+	second := r.NewMutex(name, WithDetectSelfLock())
+	if err := second.Lock(ctx); !errors.Is(err, ErrSelfLock) {
+		t.Fatalf("expected ErrSelfLock, got %v", err)
+	}
+
+	// A holder in another process is waited for as usual.
+	other := New(mockRedisClient()).NewMutex(name, WithDetectSelfLock(), WithRetryDelay(20*time.Millisecond))
+	shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := other.Lock(shortCtx); err == nil || errors.Is(err, ErrSelfLock) {
+		t.Errorf("expected a timeout for a foreign holder, got %v", err)
+	}
+
+	first.Unlock(ctx)
+	if err := second.Lock(ctx); err != nil {
+		t.Fatalf("expected lock after release, got %v", err)
+	}
+	second.Unlock(ctx)
+}
+
+func TestMutex_Handoff(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-handoff"
+	client.Del(ctx, handoffKey(name))
+
+	holder := r.NewMutex(name, WithHandoff())
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+
+	// A long retry delay leaves the handoff as the only way to wake up.
+	var mu sync.Mutex
+	var inside, acquired int
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := r.NewMutex(name, WithHandoff(), WithRetryDelay(10*time.Second))
+			if err := m.Lock(ctx); err != nil {
+				t.Errorf("waiter failed to acquire lock: %v", err)
+				return
+			}
+			mu.Lock()
+			inside++
+			acquired++
+			if inside > 1 {
+				t.Error("expected one holder at a time")
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			inside--
+			mu.Unlock()
+			m.Unlock(ctx)
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	holder.Unlock(ctx)
+	wg.Wait()
+	if acquired != 3 {
+		t.Fatalf("expected all waiters to acquire the lock, got %d", acquired)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected handoffs without waiting for the retry delay, took %v", elapsed)
+	}
+}
+
+func TestMutex_HandoffPatient(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-handoff-patient"
+	client.Del(ctx, handoffKey(name))
+
+	holder := r.NewMutex(name, WithHandoff())
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	// The second BRPOP would block for a second, past the patient.
+	hook := &attemptTimesHook{cmd: "brpop"}
+	counted := mockRedisClient()
+	counted.AddHook(hook)
+	waiter := r.NewMutex(name, WithHandoff(), WithRetryDelay(10*time.Second), WithClient(counted))
+	waiter.patient = 1500 * time.Millisecond
+	start := time.Now()
+	if err := waiter.Lock(ctx); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= waiter.patient {
+		t.Errorf("expected a last attempt without blocking, took %v", elapsed)
+	}
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	if len(hook.times) != 1 {
+		t.Errorf("expected a single BRPOP, got %d", len(hook.times))
+	}
+}
+
+func TestPSLock_History(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-pslock-history"
+	client.Del(ctx, historyKey(name))
+
+	mutex := r.NewMutex(name, WithHistory(3))
+	for range 2 {
+		if err := mutex.Lock(ctx); err != nil {
+			t.Fatalf("lock failed: %v", err)
+		}
+		if err := mutex.Unlock(ctx); err != nil {
+			t.Fatalf("unlock failed: %v", err)
+		}
+	}
+
+	events, err := r.History(ctx, name, 10)
+	if err != nil {
+		t.Fatalf("history failed: %v", err)
+	}
+	want := []EventType{EventReleased, EventAcquired, EventReleased}
+	if len(events) != len(want) {
+		t.Fatalf("expected history capped at %d events, got %d", len(want), len(events))
+	}
+	for i, e := range events {
+		if e.Event != want[i] || e.Holder != name || e.Time.IsZero() {
+			t.Errorf("unexpected event %d: %+v", i, e)
+		}
+	}
+
+	// LRANGE would read an end of -1 as the whole list.
+	if events, err := r.History(ctx, name, 0); err != nil || len(events) != 0 {
+		t.Errorf("expected no events for n = 0, got %v, %v", events, err)
+	}
+
+	// A slow history write does not hold up the acquisition.
+	slow := mockRedisClient()
+	slow.AddHook(&stallingHook{cmd: "lpush", delay: time.Second})
+	logger := &bufferLogger{}
+	stalled := New(slow).NewMutex(name, WithHistory(3), WithOpTimeout(50*time.Millisecond), WithLogger(logger))
+	start := time.Now()
+	if err := stalled.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the history write to be bounded by the op timeout, took %v", elapsed)
+	}
+	if !strings.Contains(logger.String(), "failed to record history") {
+		t.Errorf("expected the timed out history write to be logged, got %q", logger.String())
+	}
+	stalled.Unlock(ctx)
+}
+
+func TestMutex_MaxWaiters(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-max-waiters"
+	client.Del(ctx, waitersKey(name))
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for range 2 {
+		go r.NewMutex(name, WithRetryDelay(5*time.Second)).Lock(waitCtx)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	err := r.NewMutex(name, WithMaxWaiters(2)).Lock(ctx)
+	if !errors.Is(err, ErrTooManyWaiters) {
+		t.Fatalf("expected ErrTooManyWaiters, got %v", err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("expected the acquisition to be refused without waiting")
+	}
+
+	cancel()
+	holder.Unlock(ctx)
+}
+
+func TestMutex_OpTimeout(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+
+	slowClient := mockRedisClient()
+	slowClient.AddHook(&stallingHook{cmd: "set", delay: time.Second})
+	mutex := r.NewMutex("test-mutex-op-timeout", WithClient(slowClient), WithOpTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	err := mutex.Lock(ctx)
+	if !errors.Is(err, ErrOpTimeout) || errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrOpTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected Lock to fail after the op timeout, took %v", elapsed)
+	}
+
+	// A transient retry gets another attempt after the timed out one.
+	failing := mockRedisClient()
+	failing.AddHook(&failingHook{cmd: "set", n: 1, err: ErrOpTimeout})
+	retried := r.NewMutex("test-mutex-op-timeout-retry", WithClient(failing), WithOpTimeout(50*time.Millisecond), WithTransientRetries(1))
+	if err := retried.Lock(ctx); err != nil {
+		t.Fatalf("expected the retry to acquire the lock, got %v", err)
+	}
+	retried.Unlock(ctx)
+
+	// A retry finds the lock taken by the timed out attempt, instead of
+	// waiting for itself.
+	late := mockRedisClient()
+	late.AddHook(&lateReplyHook{cmd: "set", n: 1, delay: 200 * time.Millisecond})
+	name := "test-mutex-op-timeout-applied"
+	client.Del(ctx, lockPrefix+name)
+	applied := r.NewMutex(name, WithClient(late), WithOpTimeout(50*time.Millisecond), WithTransientRetries(1))
+	applied.patient = 500 * time.Millisecond
+	if err := applied.Lock(ctx); err != nil {
+		t.Fatalf("expected the retry to find the lock acquired, got %v", err)
+	}
+	if err := applied.Unlock(ctx); err != nil {
+		t.Errorf("unlock failed: %v", err)
+	}
+
+	// Extensions and checks of a held lock are bounded as well.
+	blocked := mockRedisClient()
+	held := r.NewMutex("test-mutex-op-timeout-extend", WithClient(blocked), WithOpTimeout(50*time.Millisecond))
+	if err := held.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer client.Del(ctx, held.getKey())
+	blocked.AddHook(&stallingHook{cmd: "evalsha", delay: time.Second})
+	blocked.AddHook(&stallingHook{cmd: "get", delay: time.Second})
+	start = time.Now()
+	if err := held.Extend(ctx); !errors.Is(err, ErrOpTimeout) {
+		t.Errorf("expected Extend to fail with ErrOpTimeout, got %v", err)
+	}
+	if _, err := held.Valid(ctx); !errors.Is(err, ErrOpTimeout) {
+		t.Errorf("expected Valid to fail with ErrOpTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected Extend and Valid to fail after the op timeout, took %v", elapsed)
+	}
+}
+
+func TestMutex_Slog(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-slog"
+
+	var buf strings.Builder
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	mutex := r.NewMutex(name, WithSlog(logger))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	mutex.Unlock(ctx)
+	mutex.Unlock(ctx)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 records, got %q", buf.String())
+	}
+	for i, want := range []string{
+		`"key":"test-mutex-slog","tries":1,`,
+		`"outcome":"released"`,
+		`"outcome":"not_held"`,
+	} {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("expected record %d to contain %s, got %s", i, want, lines[i])
+		}
+	}
+	if !strings.Contains(lines[0], `"outcome":"acquired"`) || !strings.Contains(lines[0], `"elapsed":`) {
+		t.Errorf("expected acquisition record with outcome and elapsed, got %s", lines[0])
+	}
+}
+
+func TestMutex_AutoRenewRetriesTransientFailure(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-auto-renew-retry"
+
+	client := mockRedisClient()
+	hook := &failingHook{cmd: "evalsha", n: 1, err: io.ErrUnexpectedEOF}
+	client.AddHook(hook)
+
+	lost := make(chan error, 1)
+	mutex := r.NewMutex(name,
+		WithClient(client),
+		WithExpiry(300*time.Millisecond),
+		WithAutoRenew(),
+		WithLogger(&bufferLogger{}),
+		WithOnLost(func(ctx context.Context, m *Mutex, err error) {
+			lost <- err
+		}),
+	)
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer mutex.Unlock(ctx)
+
+	select {
+	case err := <-lost:
+		t.Fatalf("expected the renewal to recover from one failure, lost with %v", err)
+	case <-time.After(700 * time.Millisecond):
+	}
+	hook.mu.Lock()
+	calls := hook.calls
+	hook.mu.Unlock()
+	if calls < 2 {
+		t.Errorf("expected the failed extension to be retried, got %d calls", calls)
+	}
+	if _, err := mutex.TTL(ctx); err != nil {
+		t.Errorf("expected the lock to be held, got %v", err)
+	}
+}
+
+func TestMutex_TryLock(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-try-lock"
+
+	holder := r.NewMutex(name, WithOwnerID("holder-1"))
+	if ok, err := holder.TryLock(ctx); err != nil || !ok {
+		t.Fatalf("expected TryLock to acquire a free lock, got %v, %v", ok, err)
+	}
+
+	other := r.NewMutex(name)
+	start := time.Now()
+	if ok, err := other.TryLock(ctx); err != nil || ok {
+		t.Fatalf("expected TryLock to fail on a held lock, got %v, %v", ok, err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("expected TryLock not to wait")
+	}
+
+	ok, current, err := other.TryLockWithHolder(ctx)
+	if err != nil || ok {
+		t.Fatalf("expected TryLockWithHolder to fail on a held lock, got %v, %v", ok, err)
+	}
+	if current != "holder-1" {
+		t.Errorf("expected holder %q, got %q", "holder-1", current)
+	}
+
+	holder.Unlock(ctx)
+	ok, current, err = other.TryLockWithHolder(ctx)
+	if err != nil || !ok || current != "" {
+		t.Fatalf("expected TryLockWithHolder to acquire a free lock, got %v, %q, %v", ok, current, err)
+	}
+	if err := other.Unlock(ctx); err != nil {
+		t.Errorf("unlock failed: %v", err)
+	}
+}
+
+type testContextKey string
+
+func TestMutex_ContextMetadata(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.WithValue(context.Background(), testContextKey("trace_id"), "trace-1")
+	name := "test-mutex-context-metadata"
+
+	mutex := r.NewMutex(name, WithContextMetadata(testContextKey("trace_id"), testContextKey("request_id")))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+
+	metadata, err := r.Metadata(ctx, name)
+	if err != nil {
+		t.Fatalf("metadata failed: %v", err)
+	}
+	if len(metadata) != 1 || metadata["trace_id"] != "trace-1" {
+		t.Errorf("expected trace ID metadata only, got %v", metadata)
+	}
+
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	if _, err := r.Metadata(ctx, name); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld after unlock, got %v", err)
+	}
+}
+
+func TestMutex_Eval(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-eval"
+
+	extend := `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`
+	mutex := r.NewMutex(name, WithExpiry(time.Second))
+	if res, err := mutex.Eval(ctx, extend, 60000); err != nil || res != int64(0) {
+		t.Fatalf("expected no effect before locking, got %v, %v", res, err)
+	}
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer mutex.Unlock(ctx)
+
+	if res, err := mutex.Eval(ctx, extend, 60000); err != nil || res != int64(1) {
+		t.Fatalf("expected the script to extend the lock, got %v, %v", res, err)
+	}
+	if ttl, err := mutex.TTL(ctx); err != nil || ttl <= time.Second {
+		t.Errorf("expected the extended TTL, got %v, %v", ttl, err)
+	}
+}
+
+// configHook answers CONFIG GET notify-keyspace-events with flags.
+type configHook struct {
+	flags string
+}
+
+func (h *configHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *configHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if c, ok := cmd.(*redis.MapStringStringCmd); ok && cmd.Name() == "config" {
+			c.SetVal(map[string]string{"notify-keyspace-events": h.flags})
+			return nil
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *configHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestPSLock_OnExpired(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	name := "test-pslock-on-expired"
+
+	disabled := mockRedisClient()
+	disabled.AddHook(&configHook{flags: ""})
+	err := New(disabled).OnExpired(ctx, name, func() {})
+	if !errors.Is(err, ErrNotificationsDisabled) {
+		t.Fatalf("expected ErrNotificationsDisabled, got %v", err)
+	}
+
+	client := mockRedisClient()
+	client.AddHook(&configHook{flags: "Ex"})
+	expired := make(chan struct{}, 1)
+	if err := New(client).OnExpired(ctx, name, func() { expired <- struct{}{} }); err != nil {
+		t.Fatalf("on expired failed: %v", err)
+	}
+
+	// Simulate the events Redis publishes on expiry.
+	client.Publish(ctx, "__keyevent@0__:expired", lockPrefix+"test-pslock-on-expired-other")
+	client.Publish(ctx, "__keyevent@0__:expired", lockPrefix+name)
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("expected fn to be called on the expired event")
+	}
+	select {
+	case <-expired:
+		t.Error("expected fn to be called for the watched key only")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMutex_CircuitBreaker(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+
+	client := mockRedisClient()
+	hook := &failingHook{cmd: "set", n: 2, err: io.ErrUnexpectedEOF}
+	client.AddHook(hook)
+	mutex := r.NewMutex("test-mutex-circuit-breaker", WithClient(client), WithCircuitBreaker(2, 100*time.Millisecond))
+
+	for range 2 {
+		if err := mutex.Lock(ctx); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected the Redis error, got %v", err)
+		}
+	}
+	if s := mutex.BreakerState(); s != BreakerOpen {
+		t.Fatalf("expected open breaker, got %v", s)
+	}
+	if err := mutex.Lock(ctx); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	hook.mu.Lock()
+	calls := hook.calls
+	hook.mu.Unlock()
+	if calls != 2 {
+		t.Errorf("expected the open breaker to skip Redis, got %d calls", calls)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("expected the trial to acquire the lock, got %v", err)
+	}
+	defer mutex.Unlock(ctx)
+	if s := mutex.BreakerState(); s != BreakerClosed {
+		t.Errorf("expected closed breaker after the trial, got %v", s)
+	}
+
+	// A retry decider giving up is no Redis failure.
+	aborting := r.NewMutex("test-mutex-circuit-breaker",
+		WithCircuitBreaker(2, time.Minute),
+		WithRetryDelay(10*time.Millisecond),
+		WithRetryDecider(func(ctx context.Context, try int, elapsed time.Duration) bool { return false }),
+	)
+	for range 3 {
+		if err := aborting.Lock(ctx); !errors.Is(err, ErrRetryAborted) {
+			t.Fatalf("expected ErrRetryAborted, got %v", err)
+		}
+	}
+	if s := aborting.BreakerState(); s != BreakerClosed {
+		t.Errorf("expected aborted retries to keep the breaker closed, got %v", s)
+	}
+}
+
+func TestMutex_LockIf(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-lock-if"
+
+	stale := r.NewMutex(name, WithOwnerID("stale-owner"))
+	if err := stale.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+
+	mutex := r.NewMutex(name)
+	ok, err := mutex.LockIf(ctx, func(current string) bool { return current == "live-owner" })
+	if err != nil || ok {
+		t.Fatalf("expected no takeover when the predicate fails, got %v, %v", ok, err)
+	}
+
+	var seen string
+	ok, err = mutex.LockIf(ctx, func(current string) bool {
+		seen = current
+		return current == "stale-owner"
+	})
+	if err != nil || !ok {
+		t.Fatalf("expected takeover of the stale holder, got %v, %v", ok, err)
+	}
+	if seen != "stale-owner" {
+		t.Errorf("expected predicate to see the holder value, got %q", seen)
+	}
+	if err := stale.Unlock(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected the stale holder to have lost the lock, got %v", err)
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Errorf("unlock failed: %v", err)
+	}
+}
+
+// recordingObserver records the reported operations.
+type recordingObserver struct {
+	mu    sync.Mutex
+	stats []OpStats
+}
+
+func (o *recordingObserver) ObserveOp(stats OpStats) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stats = append(o.stats, stats)
+}
+
+func TestMutex_Observer(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-observer"
+
+	observer := &recordingObserver{}
+	mutex := r.NewMutex(name, WithObserver(observer), WithClient(mockRedisClient()))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	if len(observer.stats) != 2 {
+		t.Fatalf("expected 2 observed operations, got %d", len(observer.stats))
+	}
+	lock, unlock := observer.stats[0], observer.stats[1]
+	if lock.Op != "lock" || lock.Key != name || lock.Commands != 1 || lock.Err != nil {
+		t.Errorf("expected an uncontended lock with a single command, got %+v", lock)
+	}
+	// The release script and the unlock notification, plus a script load
+	// if the script is not cached yet.
+	if unlock.Op != "unlock" || unlock.Commands < 2 || unlock.Err != nil {
+		t.Errorf("expected an unlock with at least 2 commands, got %+v", unlock)
+	}
+}
+
+func TestPSLock_HeldLocks(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+
+	mutexes := []*Mutex{
+		r.NewMutex("test-pslock-held-1", WithExpiry(time.Second)),
+		r.NewMutex("test-pslock-held-2", WithExpiry(time.Second)),
+	}
+	for _, m := range mutexes {
+		if err := m.Lock(ctx); err != nil {
+			t.Fatalf("lock failed: %v", err)
+		}
+	}
+	if held := r.HeldLocks(); len(held) != 2 {
+		t.Fatalf("expected 2 held locks, got %d", len(held))
+	}
+
+	mutexes[0].Unlock(ctx)
+	if held := r.HeldLocks(); len(held) != 1 || held[0] != mutexes[1] {
+		t.Fatalf("expected only the second lock to be held, got %v", held)
+	}
+	mutexes[0].Lock(ctx)
+
+	time.Sleep(500 * time.Millisecond)
+	if err := r.RenewAll(ctx); err != nil {
+		t.Fatalf("renew all failed: %v", err)
+	}
+	for _, m := range mutexes {
+		if ttl, err := m.TTL(ctx); err != nil || ttl <= 500*time.Millisecond {
+			t.Errorf("expected lock %q to be renewed, got %v, %v", m.Name(), ttl, err)
+		}
+	}
+
+	if err := r.ReleaseAll(ctx); err != nil {
+		t.Fatalf("release all failed: %v", err)
+	}
+	if held := r.HeldLocks(); len(held) != 0 {
+		t.Errorf("expected no held locks, got %d", len(held))
+	}
+}
+
+func TestMutex_RetryDecider(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-retry-decider"
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	var tries []int
+	waiter := r.NewMutex(name,
+		WithRetryDelay(10*time.Millisecond),
+		WithRetryDecider(func(ctx context.Context, try int, elapsed time.Duration) bool {
+			tries = append(tries, try)
+			return try < 3
+		}),
+	)
+	err := waiter.Lock(ctx)
+	if !errors.Is(err, ErrRetryAborted) {
+		t.Fatalf("expected ErrRetryAborted, got %v", err)
+	}
+	if len(tries) != 3 || tries[2] != 3 {
+		t.Errorf("expected the decider to be asked 3 times, got %v", tries)
+	}
+}
+
+func TestMutex_TriesExhausted(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-tries-exhausted"
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	waiter := r.NewMutex(name, WithTries(2), WithRetryDelay(10*time.Millisecond))
+	if err := waiter.Lock(ctx); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout once the tries are exhausted, got %v", err)
+	}
+	if v, _ := client.Get(ctx, holder.getKey()).Result(); v != holder.value {
+		t.Error("expected the holder to keep the lock")
+	}
+}
+
+func TestMutex_LockOrObserve(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-lock-or-observe"
+
+	holder := r.NewMutex(name)
+	if ok, err := holder.LockOrObserve(ctx); err != nil || !ok {
+		t.Fatalf("expected a free lock to be acquired, got %v, %v", ok, err)
+	}
+
+	observer := r.NewMutex(name, WithRetryDelay(time.Second))
+	done := make(chan bool, 1)
+	go func() {
+		ok, err := observer.LockOrObserve(ctx)
+		if err != nil {
+			t.Errorf("observe failed: %v", err)
+		}
+		done <- ok
+	}()
+	time.Sleep(100 * time.Millisecond)
+	holder.Unlock(ctx)
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("expected the observer not to acquire the lock after the unlock")
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("expected the observer to return on the unlock")
+	}
+	if n, _ := client.Exists(ctx, holder.getKey()).Result(); n != 0 {
+		t.Error("expected the lock to stay free")
+	}
+}
+
+// closedNotifier hands out subscriptions whose channel is closed, like
+// after a lost pub/sub connection.
+type closedNotifier struct {
+	PollOnlyNotifier
+}
+
+func (closedNotifier) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	return closedSubscription{}, nil
+}
+
+type closedSubscription struct{}
+
+func (closedSubscription) Channel() <-chan string {
+	ch := make(chan string)
+	close(ch)
+	return ch
+}
+
+func (closedSubscription) Close() error {
+	return nil
+}
+
+func TestMutex_LockOrObserveClosedSubscription(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-lock-or-observe-closed"
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	// A closed channel is no unlock notification.
+	observer := r.NewMutex(name, WithNotifier(closedNotifier{}), WithRetryDelay(time.Second))
+	ok, err := observer.LockOrObserve(ctx)
+	if err == nil || ok {
+		t.Errorf("expected an error for the closed subscription, got %v, %v", ok, err)
+	}
+}
+
+// droppingNotifier delivers nothing to subscribers, like a lost pub/sub
+// message, but publishes normally.
+type droppingNotifier struct {
+	Notifier
+}
+
+func (n droppingNotifier) Subscribe(ctx context.Context, channel string) (Subscription, error) {
+	return PollOnlyNotifier{}.Subscribe(ctx, channel)
+}
+
+func TestMutex_SafetyPoll(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-safety-poll"
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+
+	// With missed notifications and a long retry delay, only the safety
+	// poll picks up the release in time.
+	waiter := r.NewMutex(name,
+		WithNotifier(droppingNotifier{NewRedisNotifier(client)}),
+		WithRetryDelay(10*time.Second),
+		WithSafetyPoll(50*time.Millisecond),
+	)
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	holder.Unlock(ctx)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waiter failed to acquire lock: %v", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("expected the safety poll to acquire the released lock")
+	}
+	waiter.Unlock(ctx)
+}
+
+func TestMutex_DedicatedConn(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-dedicated-conn"
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+
+	// Without notifications the polling attempts on the dedicated
+	// connection have to pick up the release.
+	waiter := r.NewMutex(name,
+		WithNotifier(droppingNotifier{NewRedisNotifier(client)}),
+		WithRetryDelay(20*time.Millisecond),
+		WithDedicatedConn(),
+	)
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	holder.Unlock(ctx)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waiter failed to acquire lock: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the waiter to acquire the released lock")
+	}
+	waiter.Unlock(ctx)
+
+	// The connection goes back to the pool once the polling stops.
+	deadline := time.Now().Add(time.Second)
+	for {
+		stats := client.PoolStats()
+		if stats.TotalConns == stats.IdleConns {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected no connection in use, got %d of %d idle", stats.IdleConns, stats.TotalConns)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A timed out wait releases it as well.
+	holder = r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+	waiter = r.NewMutex(name,
+		WithRetryDelay(20*time.Millisecond),
+		WithDedicatedConn(),
+	)
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := waiter.Lock(waitCtx); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+	deadline = time.Now().Add(time.Second)
+	for {
+		stats := client.PoolStats()
+		if stats.TotalConns == stats.IdleConns {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected no connection in use after timeout, got %d of %d idle", stats.IdleConns, stats.TotalConns)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stallingConnHook stalls the next SET written on any connection once
+// armed, before passing it on to Redis. Unlike stallingHook it also
+// reaches connections taken with Conn, which skip the process hooks.
+type stallingConnHook struct {
+	armed atomic.Bool
+	delay time.Duration
+}
+
+func (h *stallingConnHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &stallingConn{Conn: conn, hook: h}, nil
+	}
+}
+
+func (h *stallingConnHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (h *stallingConnHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+type stallingConn struct {
+	net.Conn
+	hook *stallingConnHook
+}
+
+func (c *stallingConn) Write(b []byte) (int, error) {
+	if strings.Contains(strings.ToLower(string(b)), "\r\nset\r\n") && c.hook.armed.CompareAndSwap(true, false) {
+		time.Sleep(c.hook.delay)
+	}
+	return c.Conn.Write(b)
+}
+
+func TestMutex_DedicatedConnOpTimeout(t *testing.T) {
+	client := mockRedisClient()
+	hook := &stallingConnHook{delay: time.Second}
+	client.AddHook(hook)
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-dedicated-conn-op-timeout"
+	client.Del(ctx, lockPrefix+name)
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	waiter := r.NewMutex(name,
+		WithNotifier(PollOnlyNotifier{}),
+		WithRetryDelay(20*time.Millisecond),
+		WithDedicatedConn(),
+		WithOpTimeout(50*time.Millisecond),
+	)
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// The first poll after the release stalls; the next one must not queue
+	// up behind it on the same connection.
+	hook.armed.Store(true)
+	holder.Unlock(ctx)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waiter failed to acquire lock: %v", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("expected a poll on a fresh connection to acquire the lock")
+	}
+	waiter.Unlock(ctx)
+}
+
+func TestMutex_ObserverPhases(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-observer-phases"
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+
+	observer := &recordingObserver{}
+	waiter := r.NewMutex(name, WithObserver(observer), WithRetryDelay(time.Second))
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	holder.Unlock(ctx)
+	if err := <-done; err != nil {
+		t.Fatalf("waiter failed to acquire lock: %v", err)
+	}
+	waiter.Unlock(ctx)
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	lock, unlock := observer.stats[0], observer.stats[1]
+	for _, p := range []Phase{PhaseFastPath, PhaseSubscribe, PhaseWait} {
+		if _, ok := lock.Phases[p]; !ok {
+			t.Errorf("expected phase %q to be timed, got %v", p, lock.Phases)
+		}
+	}
+	// The waiter was woken up by the unlock message before its first poll.
+	if _, ok := lock.Phases[PhasePoll]; ok {
+		t.Errorf("expected no poll phase, got %v", lock.Phases)
+	}
+	if wait := lock.Phases[PhaseWait]; wait < 50*time.Millisecond || wait > lock.Elapsed {
+		t.Errorf("expected the wait phase to cover the blocked time, got %v of %v", wait, lock.Elapsed)
+	}
+	if unlock.Phases != nil {
+		t.Errorf("expected no phases for unlock, got %v", unlock.Phases)
+	}
+}
+
+func TestMutex_ObserverWinner(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-observer-winner"
+
+	observer := &recordingObserver{}
+	waiter := r.NewMutex(name, WithObserver(observer), WithOwnerID("worker-1"))
+	if err := waiter.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	waiter.Unlock(ctx)
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	holder.Unlock(ctx)
+	if err := <-done; err != nil {
+		t.Fatalf("waiter failed to acquire lock: %v", err)
+	}
+	waiter.Unlock(ctx)
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	if len(observer.stats) != 4 {
+		t.Fatalf("expected 4 observed operations, got %d", len(observer.stats))
+	}
+	if fast := observer.stats[0]; fast.Winner != "" {
+		t.Errorf("expected no winner for an uncontended lock, got %q", fast.Winner)
+	}
+	if won := observer.stats[2]; won.Op != "lock" || won.Winner != "worker-1" {
+		t.Errorf("expected the owner ID as winner of the contended lock, got %+v", won)
+	}
+	if unlock := observer.stats[3]; unlock.Winner != "" {
+		t.Errorf("expected no winner for unlock, got %q", unlock.Winner)
+	}
+	observer.mu.Unlock()
+
+	// Without an owner ID, the name stands for the winner.
+	named := r.NewMutex(name, WithObserver(observer), WithName("worker-2"), WithStealable())
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	go func() {
+		done <- named.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	holder.Unlock(ctx)
+	if err := <-done; err != nil {
+		t.Fatalf("waiter failed to acquire lock: %v", err)
+	}
+	named.Unlock(ctx)
+
+	observer.mu.Lock()
+	if won := observer.stats[4]; won.Winner != "worker-2" {
+		t.Errorf("expected the name as winner of the contended lock, got %q", won.Winner)
+	}
+
+	// Without either, the mutexes of a key still tell their wins apart.
+	defaults := &recordingObserver{}
+	for range 2 {
+		waiter := r.NewMutex(name, WithObserver(defaults))
+		if err := holder.Lock(ctx); err != nil {
+			t.Fatalf("holder failed to acquire lock: %v", err)
+		}
+		go func() {
+			done <- waiter.Lock(ctx)
+		}()
+		time.Sleep(100 * time.Millisecond)
+		holder.Unlock(ctx)
+		if err := <-done; err != nil {
+			t.Fatalf("waiter failed to acquire lock: %v", err)
+		}
+		waiter.Unlock(ctx)
+	}
+
+	defaults.mu.Lock()
+	defer defaults.mu.Unlock()
+	if len(defaults.stats) != 4 {
+		t.Fatalf("expected 4 observed operations, got %d", len(defaults.stats))
+	}
+	first, second := defaults.stats[0].Winner, defaults.stats[2].Winner
+	if first == "" || first == second {
+		t.Errorf("expected distinct winners for the default mutexes, got %q and %q", first, second)
+	}
+}
+
+func TestMutex_RotateToken(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-rotate-token"
+
+	mutex := r.NewMutex(name)
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	mutex.mu.Lock()
+	old := mutex.value
+	mutex.mu.Unlock()
+
+	if err := mutex.RotateToken(ctx); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	mutex.mu.Lock()
+	current := mutex.value
+	mutex.mu.Unlock()
+	if current == old {
+		t.Fatal("expected a new token after rotation")
+	}
+	if v, _ := client.Get(ctx, mutex.getKey()).Result(); v != current {
+		t.Fatalf("expected the lock to hold the new token, got %q", v)
+	}
+	if r.holder(current) != mutex || r.holder(old) != nil {
+		t.Error("expected the held token to follow the rotation")
+	}
+
+	// An extension racing the rotation with the old token, before the
+	// rotation reached Redis, commits the lock to the new token.
+	client.Set(ctx, mutex.getKey(), old, time.Second)
+	if err := mutex.extend(ctx, old); err != nil {
+		t.Fatalf("extend with the old token failed: %v", err)
+	}
+	if v, _ := client.Get(ctx, mutex.getKey()).Result(); v != current {
+		t.Fatalf("expected the extension to commit to the new token, got %q", v)
+	}
+	if err := mutex.Extend(ctx); err != nil {
+		t.Fatalf("extend right after rotation failed: %v", err)
+	}
+
+	// An unlock with the lock still on the old token releases it too.
+	client.Set(ctx, mutex.getKey(), old, time.Second)
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock right after rotation failed: %v", err)
+	}
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 0 {
+		t.Error("expected the lock to be released")
+	}
+
+	if err := mutex.RotateToken(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld when not held, got %v", err)
+	}
+}
+
+func TestMutex_RotateTokenGrace(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-rotate-token-grace"
+
+	mutex := r.NewMutex(name)
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer mutex.Unlock(ctx)
+	mutex.mu.Lock()
+	old := mutex.value
+	mutex.mu.Unlock()
+	if err := mutex.RotateToken(ctx); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+
+	// Within the grace the old token stands for the new one.
+	if err := mutex.extend(ctx, old); err != nil {
+		t.Fatalf("extend with the old token failed: %v", err)
+	}
+
+	// Past the grace it is no longer accepted.
+	mutex.mu.Lock()
+	mutex.prevUntil = time.Now()
+	mutex.mu.Unlock()
+	if err := mutex.extend(ctx, old); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld after the grace, got %v", err)
+	}
+}
+
+func TestMutex_TokenGenerator(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-token-generator"
+
+	var n int
+	mutex := r.NewMutex(name, WithTokenGenerator(func() (string, error) {
+		n++
+		return fmt.Sprintf("vault-token-%d", n), nil
+	}))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if v, _ := client.Get(ctx, mutex.getKey()).Result(); v != "vault-token-1" {
+		t.Errorf("expected the generated token, got %q", v)
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+
+	errVault := errors.New("vault unavailable")
+	failing := r.NewMutex(name, WithTokenGenerator(func() (string, error) {
+		return "", errVault
+	}))
+	if err := failing.Lock(ctx); !errors.Is(err, errVault) {
+		t.Fatalf("expected the generator error, got %v", err)
+	}
+	if n, _ := client.Exists(ctx, failing.getKey()).Result(); n != 0 {
+		t.Error("expected no lock after a failed generator")
+	}
+
+	empty := r.NewMutex(name, WithTokenGenerator(func() (string, error) {
+		return "", nil
+	}))
+	if err := empty.Lock(ctx); !errors.Is(err, errEmptyToken) {
+		t.Fatalf("expected errEmptyToken, got %v", err)
+	}
+}
+
+func TestMutex_Transfer(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-transfer"
+
+	mutex := r.NewMutex(name)
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	ok, err := mutex.Transfer(ctx, "next-owner")
+	if err != nil || !ok {
+		t.Fatalf("expected the transfer to succeed, got %v, %v", ok, err)
+	}
+	if v, _ := client.Get(ctx, mutex.getKey()).Result(); v != "next-owner" {
+		t.Fatalf("expected the lock to hold the new token, got %q", v)
+	}
+	if ttl, _ := client.PTTL(ctx, mutex.getKey()).Result(); ttl <= 0 {
+		t.Errorf("expected the TTL to be kept, got %v", ttl)
+	}
+	if len(r.HeldLocks()) != 0 {
+		t.Error("expected the mutex to no longer own the lock")
+	}
+
+	// The former owner can neither transfer nor unlock it again.
+	ok, err = mutex.Transfer(ctx, "someone-else")
+	if err != nil || ok {
+		t.Fatalf("expected a transfer by a non-owner to fail, got %v, %v", ok, err)
+	}
+	if err := mutex.Unlock(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+
+	// The new owner takes it over.
+	next := r.NewMutex(name, WithOwnerID("next-owner"))
+	if err := next.Lock(ctx); err != nil {
+		t.Fatalf("new owner failed to take over the lock: %v", err)
+	}
+	if err := next.Unlock(ctx); err != nil {
+		t.Fatalf("new owner failed to unlock: %v", err)
+	}
+}
+
+func TestMutex_NilContext(t *testing.T) {
+	r := New(mockRedisClient())
+	name := "test-mutex-nil-context"
+	mutex := r.NewMutex(name)
+
+	var nilCtx context.Context
+	if err := mutex.Lock(nilCtx); !errors.Is(err, ErrNilContext) {
+		t.Errorf("expected ErrNilContext from Lock, got %v", err)
+	}
+	if ok, err := mutex.TryLock(nilCtx); ok || !errors.Is(err, ErrNilContext) {
+		t.Errorf("expected ErrNilContext from TryLock, got %v, %v", ok, err)
+	}
+	if ok, _, err := mutex.TryLockWithHolder(nilCtx); ok || !errors.Is(err, ErrNilContext) {
+		t.Errorf("expected ErrNilContext from TryLockWithHolder, got %v, %v", ok, err)
+	}
+
+	ctx := context.Background()
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if err := mutex.Extend(nilCtx); !errors.Is(err, ErrNilContext) {
+		t.Errorf("expected ErrNilContext from Extend, got %v", err)
+	}
+	if err := mutex.Unlock(nilCtx); !errors.Is(err, ErrNilContext) {
+		t.Errorf("expected ErrNilContext from Unlock, got %v", err)
+	}
+	// The lock is still held after the rejected Unlock.
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+}
+
+func TestNewSpread(t *testing.T) {
+	ctx := context.Background()
+	// Two databases of the test server stand in for two instances.
+	clients := []*redis.Client{
+		redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}),
+		redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 2}),
+	}
+	r := NewSpread(clients)
+	other := NewSpread(clients)
+
+	used := make(map[*redis.Client]bool)
+	for i := range 16 {
+		key := fmt.Sprintf("test-spread-%d", i)
+		m := r.NewMutex(key)
+		if m.client != other.NewMutex(key).client {
+			t.Fatalf("expected key %q on the same instance for every PSLock", key)
+		}
+		used[m.client] = true
+
+		if err := m.Lock(ctx); err != nil {
+			t.Fatalf("lock of %q failed: %v", key, err)
+		}
+		if n, _ := m.client.Exists(ctx, m.getKey()).Result(); n != 1 {
+			t.Errorf("expected lock %q on its instance", key)
+		}
+		if err := m.Unlock(ctx); err != nil {
+			t.Fatalf("unlock of %q failed: %v", key, err)
+		}
+	}
+	if len(used) != 2 {
+		t.Errorf("expected keys on both instances, got %d", len(used))
+	}
+
+	// Waiters are notified through the instance of the key.
+	name := "test-spread-wait"
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	waiter := other.NewMutex(name, WithRetryDelay(10*time.Second))
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	holder.Unlock(ctx)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waiter failed to acquire lock: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the unlock message to wake up the waiter")
+	}
+	waiter.Unlock(ctx)
+}
+
+func TestNewSpread_KeyEncoding(t *testing.T) {
+	ctx := context.Background()
+	clients := []*redis.Client{
+		redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}),
+		redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 2}),
+	}
+	r := NewSpread(clients)
+
+	// A key whose encoding hashes to the other instance.
+	var key string
+	for i := 0; key == ""; i++ {
+		k := fmt.Sprintf("test-spread-encoding-%d", i)
+		if r.clientFor(k) != r.clientFor(HexKeyEncoding(k)) {
+			key = k
+		}
+	}
+	mutex := r.NewMutex(key, WithKeyEncoding(HexKeyEncoding))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	infos, err := r.InspectMany(ctx, []string{key}, WithKeyEncoding(HexKeyEncoding))
+	if err != nil || !infos[key].Held {
+		t.Fatalf("expected the lock to be found on the instance of its key, got %+v, %v", infos[key], err)
+	}
+	if err := r.ForceUnlock(ctx, key, WithKeyEncoding(HexKeyEncoding)); err != nil {
+		t.Fatalf("force unlock failed: %v", err)
+	}
+
+	// Intent children live on the instance of their parent.
+	for i := range 16 {
+		child := r.NewMutex(fmt.Sprintf("test-spread-child-%d", i), WithIntentParent("test-spread-parent"))
+		if child.client != r.clientFor("test-spread-parent") {
+			t.Fatalf("expected child %q on the instance of its parent", child.key)
+		}
+	}
+}
+
+func TestMutex_LockCtx(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-lock-ctx"
+
+	if _, ok := FromContext(ctx); ok {
+		t.Fatal("expected no lock identity in a plain context")
+	}
+
+	mutex := r.NewMutex(name)
+	lockCtx, err := mutex.LockCtx(ctx)
+	if err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer mutex.Unlock(ctx)
+
+	info, ok := FromContext(lockCtx)
+	if !ok {
+		t.Fatal("expected the lock identity in the returned context")
+	}
+	mutex.mu.Lock()
+	token := mutex.value
+	mutex.mu.Unlock()
+	if info.Key != name || info.Name != name || info.Token != token {
+		t.Errorf("expected the identity of the acquisition, got %+v", info)
+	}
+
+	// A nested lock shadows the outer one.
+	inner := r.NewMutex(name + "-inner")
+	innerCtx, err := inner.LockCtx(lockCtx)
+	if err != nil {
+		t.Fatalf("inner lock failed: %v", err)
+	}
+	defer inner.Unlock(ctx)
+	if info, _ := FromContext(innerCtx); info.Key != name+"-inner" {
+		t.Errorf("expected the inner lock identity, got %+v", info)
+	}
+}
+
+func TestMutex_PublishRetries(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-publish-retries"
+	hook := &failingHook{cmd: "publish", n: 2, err: errors.New("connection reset")}
+	client.AddHook(hook)
+
+	mutex := r.NewMutex(name, WithPublishRetries(2), WithStrictPublish(true))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+
+	// The waiter only wakes up in time through the unlock message.
+	waiter := r.NewMutex(name, WithRetryDelay(10*time.Second))
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("expected the publish to succeed on retry, got %v", err)
+	}
+	hook.mu.Lock()
+	calls := hook.calls
+	hook.mu.Unlock()
+	if calls != 3 {
+		t.Errorf("expected 3 publish attempts, got %d", calls)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waiter failed to acquire lock: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the retried unlock message to wake up the waiter")
+	}
+	waiter.Unlock(ctx)
+}
+
+func TestMutex_AdaptiveExpiry(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-adaptive-expiry"
+
+	// A fast Redis gets the minimum.
+	logger := &bufferLogger{}
+	mutex := r.NewMutex(name, WithAdaptiveExpiry(2*time.Second, 5*time.Second), WithLogger(logger))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if ttl, _ := client.PTTL(ctx, mutex.getKey()).Result(); ttl <= time.Second || ttl > 2*time.Second {
+		t.Errorf("expected the minimum expiry, got a TTL of %v", ttl)
+	}
+	if !strings.Contains(logger.String(), "uses expiry 2s") {
+		t.Errorf("expected the chosen expiry to be logged, got %q", logger.String())
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+
+	// A slow one gets the maximum, which extensions keep.
+	slow := mockRedisClient()
+	slow.AddHook(&stallingHook{cmd: "ping", delay: 10 * time.Millisecond})
+	mutex = r.NewMutex(name, WithAdaptiveExpiry(2*time.Second, 5*time.Second), WithClient(slow))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if err := mutex.Extend(ctx); err != nil {
+		t.Fatalf("extend failed: %v", err)
+	}
+	if ttl, _ := client.PTTL(ctx, mutex.getKey()).Result(); ttl <= 4*time.Second || ttl > 5*time.Second {
+		t.Errorf("expected the maximum expiry, got a TTL of %v", ttl)
+	}
+
+	// A contended Lock measures once, not on every retry.
+	pings := &attemptTimesHook{cmd: "ping"}
+	counted := mockRedisClient()
+	counted.AddHook(pings)
+	waiter := r.NewMutex(name, WithAdaptiveExpiry(2*time.Second, 5*time.Second), WithClient(counted))
+	done := make(chan error, 1)
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	mutex.Unlock(ctx)
+	if err := <-done; err != nil {
+		t.Fatalf("waiter failed to acquire lock: %v", err)
+	}
+	waiter.Unlock(ctx)
+	pings.mu.Lock()
+	defer pings.mu.Unlock()
+	if len(pings.times) != 1 {
+		t.Errorf("expected a single PING per Lock, got %d", len(pings.times))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for min above max")
+		}
+	}()
+	WithAdaptiveExpiry(5*time.Second, 2*time.Second)
+}
+
+func TestMutex_RedisFunctions(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-redis-functions"
+
+	mutex := r.NewMutex(name, WithRedisFunctions())
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if err := mutex.Extend(ctx); err != nil {
+		t.Fatalf("extend failed: %v", err)
+	}
+	if ttl, err := mutex.TTL(ctx); err != nil || ttl <= 0 {
+		t.Fatalf("expected a TTL, got %v, %v", ttl, err)
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 0 {
+		t.Error("expected the lock to be released")
+	}
+
+	// The outcome of loading the library is remembered per client,
+	// whether the server supports functions or not.
+	r.mu.Lock()
+	_, ok := r.functions[client]
+	r.mu.Unlock()
+	if !ok {
+		t.Error("expected the function support of the client to be recorded")
+	}
+}
+
+func TestMutex_UnlockDefer(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-unlock-defer"
+
+	logger := &bufferLogger{}
+	mutex := r.NewMutex(name, WithLogger(logger))
+	func() {
+		if err := mutex.Lock(ctx); err != nil {
+			t.Fatalf("lock failed: %v", err)
+		}
+		defer mutex.UnlockDefer(ctx)
+	}()
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 0 {
+		t.Error("expected the deferred unlock to release the lock")
+	}
+	if logger.String() != "" {
+		t.Errorf("expected nothing logged for a successful unlock, got %q", logger.String())
+	}
+
+	// A lock lost meanwhile is reported to the logger.
+	func() {
+		if err := mutex.Lock(ctx); err != nil {
+			t.Fatalf("lock failed: %v", err)
+		}
+		defer mutex.UnlockDefer(ctx)
+		client.Del(ctx, mutex.getKey())
+	}()
+	if !strings.Contains(logger.String(), "unlock of lock") || !strings.Contains(logger.String(), ErrLockNotHeld.Error()) {
+		t.Errorf("expected the failed unlock to be logged, got %q", logger.String())
+	}
+}
+
+func TestMutex_LockChan(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-lock-chan"
+
+	holder := r.NewMutex(name)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+
+	waiter := r.NewMutex(name)
+	acquired := waiter.LockChan(ctx)
+	other := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(other)
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("expected the lock to be busy, got %v", err)
+	case <-other:
+	}
+
+	holder.Unlock(ctx)
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("waiter failed to acquire lock: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the waiter to acquire the released lock")
+	}
+
+	// A caller that gave up does not leave the lock held.
+	cancelCtx, cancel := context.WithCancel(ctx)
+	abandoned := r.NewMutex(name).LockChan(cancelCtx)
+	cancel()
+	waiter.Unlock(ctx)
+	if err := <-abandoned; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if n, _ := client.Exists(ctx, waiter.getKey()).Result(); n != 0 {
+		t.Error("expected no lock left held by the abandoned acquisition")
+	}
+}
+
+func TestMutex_MaxSubscriptions(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379", PoolSize: 4})
+	r := New(client)
+	defer r.Close()
+	ctx := context.Background()
+
+	const keys = 30
+	holders := make([]*Mutex, keys)
+	for i := range holders {
+		holders[i] = r.NewMutex(fmt.Sprintf("test-mutex-max-subscriptions-%d", i))
+		if err := holders[i].Lock(ctx); err != nil {
+			t.Fatalf("holder %d failed to acquire lock: %v", i, err)
+		}
+	}
+
+	// The waiters only wake up in time through their unlock message.
+	done := make(chan error, keys)
+	for i := range keys {
+		waiter := r.NewMutex(holders[i].Name(), WithRetryDelay(10*time.Second), WithMaxSubscriptions(2))
+		go func() {
+			err := waiter.Lock(ctx)
+			if err == nil {
+				err = waiter.Unlock(ctx)
+			}
+			done <- err
+		}()
+	}
+	time.Sleep(300 * time.Millisecond)
+	if stats := client.PoolStats(); stats.TotalConns > 4+2 {
+		t.Errorf("expected at most 2 pub/sub connections, got %d connections", stats.TotalConns)
+	}
+
+	for _, holder := range holders {
+		holder.Unlock(ctx)
+	}
+	timeout := time.After(2 * time.Second)
+	for range keys {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("waiter failed: %v", err)
+			}
+		case <-timeout:
+			t.Fatal("expected every waiter to be woken up by its unlock message")
+		}
+	}
+}
+
+func TestMutex_RandSource(t *testing.T) {
+	r := New(mockRedisClient())
+	name := "test-mutex-rand-source"
+
+	// Equally seeded mutexes draw the same delays.
+	first := r.NewMutex(name, WithRandSource(rand.NewSource(42)), WithExpiryJitter(0.1))
+	second := r.NewMutex(name, WithRandSource(rand.NewSource(42)), WithExpiryJitter(0.1))
+	for i := range 10 {
+		if d1, d2 := first.delayFunc(i), second.delayFunc(i); d1 != d2 {
+			t.Fatalf("expected reproducible retry delays, got %v and %v", d1, d2)
+		}
+		if e1, e2 := first.jitteredExpiry(), second.jitteredExpiry(); e1 != e2 {
+			t.Fatalf("expected reproducible expiries, got %v and %v", e1, e2)
+		}
+	}
+
+	// Default mutexes have generators of their own.
+	if r.NewMutex(name).rand == r.NewMutex(name).rand {
+		t.Error("expected a generator per mutex")
+	}
+}
+
+// benchmarkRetryDelay draws default retry delays from many goroutines,
+// each with a mutex of its own.
+func benchmarkRetryDelay(b *testing.B, delay func(m *Mutex) time.Duration) {
+	r := New(mockRedisClient())
+	b.RunParallel(func(pb *testing.PB) {
+		m := r.NewMutex("bench-mutex-retry-delay")
+		for pb.Next() {
+			delay(m)
+		}
+	})
+}
+
+func BenchmarkMutex_RetryDelayGlobalRand(b *testing.B) {
+	benchmarkRetryDelay(b, func(*Mutex) time.Duration {
+		return time.Duration(rand.Intn(maxRetryDelayMilliSec-minRetryDelayMilliSec)+minRetryDelayMilliSec) * time.Millisecond
+	})
+}
+
+func BenchmarkMutex_RetryDelayMutexRand(b *testing.B) {
+	benchmarkRetryDelay(b, func(m *Mutex) time.Duration {
+		return m.delayFunc(0)
+	})
+}
+
+func TestMutex_EnabledKey(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-enabled-key"
+	flagKey := "test-mutex-enabled-key-flag"
+
+	// Locking is disabled by the flag.
+	client.Set(ctx, flagKey, "false", 0)
+	defer client.Del(ctx, flagKey)
+	mutex := r.NewMutex(name, WithEnabledKey(flagKey))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 0 {
+		t.Error("expected no lock taken while the flag is disabled")
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Errorf("expected the unlock to be a no-op, got %v", err)
+	}
+
+	// A change of the flag applies once the cache expired.
+	client.Set(ctx, flagKey, "1", 0)
+	r.mu.Lock()
+	delete(r.flags, flagCacheKey{client: client, key: flagKey})
+	r.mu.Unlock()
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 1 {
+		t.Error("expected the lock to be taken while the flag is enabled")
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+
+	// A missing flag enforces the lock.
+	other := r.NewMutex(name, WithEnabledKey("test-mutex-enabled-key-missing"))
+	if err := other.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer other.Unlock(ctx)
+	if n, _ := client.Exists(ctx, other.getKey()).Result(); n != 1 {
+		t.Error("expected a missing flag to enforce the lock")
+	}
+
+	// The flag is cached per client, like on the instances of NewSpread.
+	client.Set(ctx, flagKey, "false", 0)
+	r.mu.Lock()
+	delete(r.flags, flagCacheKey{client: client, key: flagKey})
+	r.mu.Unlock()
+	if r.lockingEnabled(ctx, client, flagKey) {
+		t.Fatal("expected the flag to disable locking on its client")
+	}
+	db1 := redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1})
+	db1.Del(ctx, flagKey)
+	enforced := r.NewMutex(name+"-db1", WithEnabledKey(flagKey), WithClient(db1))
+	if err := enforced.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer enforced.Unlock(ctx)
+	if n, _ := db1.Exists(ctx, enforced.getKey()).Result(); n != 1 {
+		t.Error("expected the flag of another client not to apply")
+	}
+}
+
+func TestMutex_RenewOrReacquire(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-renew-or-reacquire"
+
+	mutex := r.NewMutex(name, WithExpiry(time.Second))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer mutex.Unlock(ctx)
+
+	// Still held: the lock is extended.
+	client.PExpire(ctx, mutex.getKey(), 100*time.Millisecond)
+	reacquired, err := mutex.RenewOrReacquire(ctx)
+	if err != nil || reacquired {
+		t.Fatalf("expected an extension, got %v, %v", reacquired, err)
+	}
+	if ttl, _ := client.PTTL(ctx, mutex.getKey()).Result(); ttl <= 500*time.Millisecond {
+		t.Errorf("expected the expiry to be reset, got a TTL of %v", ttl)
+	}
+
+	// Expired and free: the lock is taken again, signalling the gap.
+	client.Del(ctx, mutex.getKey())
+	reacquired, err = mutex.RenewOrReacquire(ctx)
+	if err != nil || !reacquired {
+		t.Fatalf("expected a reacquisition, got %v, %v", reacquired, err)
+	}
+	mutex.mu.Lock()
+	value := mutex.value
+	mutex.mu.Unlock()
+	if v, _ := client.Get(ctx, mutex.getKey()).Result(); v != value {
+		t.Errorf("expected the lock to hold our value again, got %q", v)
+	}
+
+	// Taken by another holder: the lock is lost.
+	client.Set(ctx, mutex.getKey(), "other", time.Second)
+	if _, err := mutex.RenewOrReacquire(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+	client.Del(ctx, mutex.getKey())
+}
+
+func TestMutex_ObserverHeld(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	name := "test-mutex-observer-held"
+
+	observer := &recordingObserver{}
+	mutex := r.NewMutex(name, WithObserver(observer))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	// Unlocking again does not report a hold.
+	mutex.Unlock(ctx)
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	lock, unlock, again := observer.stats[0], observer.stats[1], observer.stats[2]
+	if lock.Held != 0 {
+		t.Errorf("expected no hold duration for lock, got %v", lock.Held)
+	}
+	if unlock.Held < 50*time.Millisecond || unlock.Held > time.Second {
+		t.Errorf("expected the hold duration, got %v", unlock.Held)
+	}
+	if again.Held != 0 {
+		t.Errorf("expected no hold duration for an unlock without hold, got %v", again.Held)
+	}
+}
+
+func TestPSLock_PreSubscribe(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379", PoolSize: 4})
+	r := New(client)
+	defer r.Close()
+	ctx := context.Background()
+	key := "test-mutex-presubscribe"
+
+	if err := r.PreSubscribe(ctx, key); err != nil {
+		t.Fatalf("failed to pre-subscribe: %v", err)
+	}
+	holder := r.NewMutex(key)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("failed to acquire lock: %v", err)
+	}
+
+	// The waiters only wake up in time through their unlock message.
+	const waiters = 10
+	done := make(chan error, waiters)
+	for range waiters {
+		waiter := r.NewMutex(key, WithRetryDelay(10*time.Second))
+		go func() {
+			err := waiter.Lock(ctx)
+			if err == nil {
+				err = waiter.Unlock(ctx)
+			}
+			done <- err
+		}()
+	}
+	time.Sleep(300 * time.Millisecond)
+	if stats := client.PoolStats(); stats.TotalConns > 4+1 {
+		t.Errorf("expected the waiters to share the persistent subscription, got %d connections", stats.TotalConns)
+	}
+
+	holder.Unlock(ctx)
+	timeout := time.After(2 * time.Second)
+	for range waiters {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("waiter failed: %v", err)
+			}
+		case <-timeout:
+			t.Fatal("expected every waiter to be woken up by its unlock message")
+		}
+	}
+
+	if err := r.Unsubscribe(key); err != nil {
+		t.Fatalf("failed to unsubscribe: %v", err)
+	}
+	if _, ok := r.presubscribed[lockPrefix+key]; ok {
+		t.Error("expected the persistent subscription to be torn down")
+	}
+}
+
+func TestMutex_AuxTTL(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	key := "test-mutex-aux-ttl"
+	aux := []string{waitersKey(key), handoffKey(key), historyKey(key)}
+	client.Del(ctx, aux...)
+
+	// Crashed waiters are counted but never uncounted, and the handoff
+	// token pushed for them is never popped.
+	m := r.NewMutex(key, WithHistory(10), WithAuxTTL(200*time.Millisecond))
+	m.enterWaiters(ctx)
+	m.enterWaiters(ctx)
+	m.handOff(ctx)
+	m.recordHistory(ctx, EventAcquired)
+	if n, _ := client.Exists(ctx, aux...).Result(); n != int64(len(aux)) {
+		t.Fatalf("expected all auxiliary keys to exist, got %d", n)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if n, _ := client.Exists(ctx, aux...).Result(); n != 0 {
+		t.Errorf("expected the auxiliary keys to expire, got %d left", n)
+	}
+	if n, err := r.WaiterCount(ctx, key); err != nil || n != 0 {
+		t.Errorf("expected no waiters after the TTL, got %d, %v", n, err)
+	}
+}
+
+func TestMutex_Locker(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	key := "test-mutex-locker"
+	client.Del(ctx, lockPrefix+key)
+
+	var l sync.Locker = r.NewMutex(key).Locker()
+	l.Lock()
+	if ok, _ := r.NewMutex(key).TryLock(ctx); ok {
+		t.Fatal("expected the lock to be held through the Locker")
+	}
+	l.Unlock()
+
+	holder := r.NewMutex(key)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+	defer func() {
+		if recover() == nil {
+			t.Error("expected Lock of the Locker to panic when the lock cannot be acquired")
+		}
+	}()
+	waiter := r.NewMutex(key)
+	waiter.patient = 100 * time.Millisecond
+	waiter.Locker().Lock()
+}
+
+func TestMutex_ReportProgress(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	key := "test-mutex-report-progress"
+	client.Del(ctx, lockPrefix+key)
+
+	const expiry = 300 * time.Millisecond
+	mutex := r.NewMutex(key, WithExpiry(expiry), WithAutoRenew())
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if got := mutex.renewInterval(expiry); got != expiry/3 {
+		t.Errorf("expected an extension every third of the expiry, got %v", got)
+	}
+	mutex.ReportProgress(2)
+	if got := mutex.renewInterval(expiry); got != expiry/2 {
+		t.Errorf("expected an extension every half of the expiry when done, got %v", got)
+	}
+
+	// The tapered cadence still keeps the lock alive.
+	time.Sleep(2 * expiry)
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 1 {
+		t.Fatal("expected the lock to be renewed past its expiry")
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer mutex.Unlock(ctx)
+	if got := mutex.renewInterval(expiry); got != expiry/3 {
+		t.Errorf("expected the progress to be reset on acquisition, got %v", got)
+	}
+}
+
+func TestPSLock_MonitorAll(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first, second := "test-pslock-monitor-all-1", "test-pslock-monitor-all-2"
+
+	events, err := r.MonitorAll(ctx)
+	if err != nil {
+		t.Fatalf("monitor failed: %v", err)
+	}
+
+	for _, key := range []string{first, second} {
+		m := r.NewMutex(key)
+		if err := m.Lock(ctx); err != nil {
+			t.Fatalf("failed to acquire lock %q: %v", key, err)
+		}
+		m.Unlock(ctx)
+	}
+
+	want := []LockEvent{
+		{Key: first, Event: EventReleased},
+		{Key: second, Event: EventReleased},
+	}
+	for _, w := range want {
+		select {
+		case got := <-events:
+			// Skip events of locks left behind by other tests.
+			for got.Key != first && got.Key != second {
+				got = <-events
+			}
+			if got != w {
+				t.Errorf("expected event %+v, got %+v", w, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected event %+v", w)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		for ok {
+			_, ok = <-events
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the event channel to be closed once ctx is done")
+	}
+}
+
+// replicationHook fakes a Redis primary with one replica that acknowledges
+// writes only if acked is set. It answers INFO and WAIT on the connection,
+// as the connection an acquisition pins does not run the process hooks.
+type replicationHook struct {
+	mu    sync.Mutex
+	acked bool
+}
+
+func (h *replicationHook) setAcked(acked bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.acked = acked
+}
+
+func (h *replicationHook) acknowledged() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.acked {
+		return 1
+	}
+	return 0
+}
+
+func (h *replicationHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &replicationConn{Conn: conn, hook: h}, nil
+	}
+}
+
+func (h *replicationHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (h *replicationHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+// replicationConn replies to INFO and WAIT itself when they are sent on
+// their own, and passes everything else to Redis.
+type replicationConn struct {
+	net.Conn
+	hook  *replicationHook
+	reply []byte
+}
+
+func (c *replicationConn) Write(b []byte) (int, error) {
+	cmd := strings.ToLower(string(b))
+	switch {
+	case strings.HasPrefix(cmd, "*2\r\n$4\r\ninfo\r\n"):
+		info := "# Replication\r\nrole:master\r\nconnected_slaves:1\r\n"
+		c.reply = fmt.Appendf(c.reply, "$%d\r\n%s\r\n", len(info), info)
+		return len(b), nil
+	case strings.HasPrefix(cmd, "*3\r\n$4\r\nwait\r\n"):
+		c.reply = fmt.Appendf(c.reply, ":%d\r\n", c.hook.acknowledged())
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *replicationConn) Read(b []byte) (int, error) {
+	if len(c.reply) > 0 {
+		n := copy(b, c.reply)
+		c.reply = c.reply[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+func TestMutex_MaxReplicationLag(t *testing.T) {
+	client := mockRedisClient()
+	hook := &replicationHook{}
+	client.AddHook(hook)
+	r := New(client)
+	ctx := context.Background()
+	key := "test-mutex-max-replication-lag"
+	client.Del(ctx, lockPrefix+key)
+
+	mutex := r.NewMutex(key, WithMaxReplicationLag(50*time.Millisecond))
+	if err := mutex.Lock(ctx); !errors.Is(err, ErrReplicationLag) {
+		t.Fatalf("expected ErrReplicationLag without replica acknowledgement, got %v", err)
+	}
+	if n, _ := client.Exists(ctx, lockPrefix+key).Result(); n != 0 {
+		t.Fatal("expected the unconfirmed acquisition to be undone")
+	}
+
+	hook.setAcked(true)
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("expected lock once the replica acknowledges, got %v", err)
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+
+	// A hold resumed with the owner ID is not undone.
+	owner := r.NewMutex(key, WithMaxReplicationLag(50*time.Millisecond), WithOwnerID("worker-1"))
+	if err := owner.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	hook.setAcked(false)
+	if err := owner.Lock(ctx); !errors.Is(err, ErrReplicationLag) {
+		t.Fatalf("expected ErrReplicationLag without replica acknowledgement, got %v", err)
+	}
+	if v, _ := client.Get(ctx, lockPrefix+key).Result(); v != "worker-1" {
+		t.Errorf("expected the resumed hold to be kept, got %q", v)
+	}
+	client.Del(ctx, lockPrefix+key)
+}
+
+func TestMutex_ExtendAsync(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	key := "test-mutex-extend-async"
+	client.Del(ctx, lockPrefix+key)
+
+	mutex := r.NewMutex(key, WithExpiry(time.Second))
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	client.PExpire(ctx, mutex.getKey(), 100*time.Millisecond)
+	select {
+	case err := <-mutex.ExtendAsync(ctx):
+		if err != nil {
+			t.Fatalf("extend failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the extension result to be delivered")
+	}
+	if ttl, _ := client.PTTL(ctx, mutex.getKey()).Result(); ttl <= 100*time.Millisecond {
+		t.Errorf("expected the expiry to be reset, got %v", ttl)
+	}
+
+	// The background extension ends with its context.
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := <-mutex.ExtendAsync(cancelCtx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	mutex.Unlock(ctx)
+	if err := <-mutex.ExtendAsync(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld after unlock, got %v", err)
+	}
+}
+
+func TestMutex_StealLock(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	key := "test-mutex-steal-lock"
+	client.Del(ctx, lockPrefix+key)
+
+	lost := make(chan error, 1)
+	victim := r.NewMutex(key,
+		WithStealable(),
+		WithPriority(1),
+		WithExpiry(150*time.Millisecond),
+		WithAutoRenew(),
+		WithLogger(&bufferLogger{}),
+		WithOnLost(func(ctx context.Context, m *Mutex, err error) { lost <- err }),
+	)
+	lockCtx, release, err := victim.LockWithRelease(ctx)
+	if err != nil {
+		t.Fatalf("victim failed to acquire lock: %v", err)
+	}
+	defer release()
+
+	if ok, err := r.NewMutex(key, WithPriority(1)).StealLock(ctx); err != nil || ok {
+		t.Fatalf("expected an equal priority not to steal the lock, got %v, %v", ok, err)
+	}
+	thief := r.NewMutex(key, WithPriority(2))
+	if ok, err := thief.StealLock(ctx); err != nil || !ok {
+		t.Fatalf("expected a higher priority to steal the lock, got %v, %v", ok, err)
+	}
+
+	// The victim aborts once its watchdog notices the loss.
+	select {
+	case <-lockCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the hold context of the victim to be cancelled")
+	}
+	if err := <-lost; !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected the victim to report ErrLockNotHeld, got %v", err)
+	}
+	if err := victim.Unlock(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected unlock of the victim to fail with ErrLockNotHeld, got %v", err)
+	}
+
+	// The thief is not stealable itself.
+	if ok, err := r.NewMutex(key, WithPriority(10)).StealLock(ctx); err != nil || ok {
+		t.Fatalf("expected a lock without WithStealable not to be stolen, got %v, %v", ok, err)
+	}
+	if err := thief.Unlock(ctx); err != nil {
+		t.Fatalf("unlock of the thief failed: %v", err)
+	}
+
+	// A token cannot pass itself off as stealable.
+	forged := r.NewMutex(key, WithTokenGenerator(func() (string, error) {
+		return stealablePrefix + "-1:token", nil
+	}))
+	if err := forged.Lock(ctx); !errors.Is(err, errStealablePrefix) {
+		t.Fatalf("expected errStealablePrefix, got %v", err)
+	}
+	if n, _ := client.Exists(ctx, forged.getKey()).Result(); n != 0 {
+		t.Error("expected no lock with a forged stealable value")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for an owner ID with the stealable mark")
+		}
+	}()
+	WithOwnerID(stealablePrefix + "-1:owner")
+}
+
+func TestMutex_IdleTimeout(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	key := "test-mutex-idle-timeout"
+	client.Del(ctx, lockPrefix+key)
+
+	mutex := r.NewMutex(key,
+		WithExpiry(150*time.Millisecond),
+		WithAutoRenew(),
+		WithIdleTimeout(200*time.Millisecond),
+		WithLogger(&bufferLogger{}),
+	)
+	if err := mutex.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+
+	// Touching keeps the lock renewed past the idle timeout.
+	for range 8 {
+		time.Sleep(50 * time.Millisecond)
+		if err := mutex.Touch(ctx); err != nil {
+			t.Fatalf("touch failed: %v", err)
+		}
+	}
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 1 {
+		t.Fatal("expected the touched lock to be renewed")
+	}
+
+	// Once idle, the lock is no longer renewed and expires.
+	time.Sleep(500 * time.Millisecond)
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 0 {
+		t.Fatal("expected the idle lock to expire")
+	}
+	if err := mutex.Unlock(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld after the idle lock expired, got %v", err)
+	}
+	if err := mutex.Touch(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected touch without a lock to fail with ErrLockNotHeld, got %v", err)
+	}
+}
+
+func TestMutex_Epoch(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	key := "test-mutex-epoch"
+	client.Del(ctx, lockPrefix+key)
+
+	before := r.NewMutex(key, WithEpoch())
+	if err := before.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if ok, err := before.Valid(ctx); err != nil || !ok {
+		t.Fatalf("expected the lock to be valid before the bump, got %v, %v", ok, err)
+	}
+	if err := before.Extend(ctx); err != nil {
+		t.Fatalf("expected extend before the bump to succeed, got %v", err)
+	}
+
+	if err := r.BumpEpoch(ctx); err != nil {
+		t.Fatalf("bump failed: %v", err)
+	}
+	if ok, err := before.Valid(ctx); ok || !errors.Is(err, ErrStaleEpoch) {
+		t.Errorf("expected ErrStaleEpoch from Valid after the bump, got %v, %v", ok, err)
+	}
+	if err := before.Extend(ctx); !errors.Is(err, ErrStaleEpoch) {
+		t.Errorf("expected ErrStaleEpoch from Extend after the bump, got %v", err)
+	}
+	if err := before.Unlock(ctx); err != nil {
+		t.Fatalf("unlock of the stale lock failed: %v", err)
+	}
+
+	after := r.NewMutex(key, WithEpoch())
+	if err := after.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer after.Unlock(ctx)
+	if ok, err := after.Valid(ctx); err != nil || !ok {
+		t.Errorf("expected a lock of the new epoch to be valid, got %v, %v", ok, err)
+	}
+	if err := after.Extend(ctx); err != nil {
+		t.Errorf("expected extend in the new epoch to succeed, got %v", err)
+	}
+}
+
+func TestMutex_LockWithResult(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	key := "test-mutex-lock-with-result"
+	client.Del(ctx, lockPrefix+key)
+
+	holder := r.NewMutex(key)
+	res, err := holder.LockWithResult(ctx)
+	if err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if res.Contended || res.Tries != 1 {
+		t.Errorf("expected an uncontended fast path acquisition, got %+v", res)
+	}
+
+	waiter := r.NewMutex(key, WithRetryDelay(20*time.Millisecond))
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		holder.Unlock(ctx)
+	}()
+	res, err = waiter.LockWithResult(ctx)
+	if err != nil {
+		t.Fatalf("waiter failed to acquire lock: %v", err)
+	}
+	if !res.Contended || res.Tries < 2 {
+		t.Errorf("expected a contended acquisition with retries, got %+v", res)
+	}
+	waiter.Unlock(ctx)
+}
+
+// attemptTimesHook records when commands named cmd are sent.
+type attemptTimesHook struct {
+	mu    sync.Mutex
+	cmd   string
+	times []time.Time
+}
+
+func (h *attemptTimesHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *attemptTimesHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == h.cmd {
+			h.mu.Lock()
+			h.times = append(h.times, time.Now())
+			h.mu.Unlock()
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *attemptTimesHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestMutex_MinRetryDelay(t *testing.T) {
+	r := New(mockRedisClient())
+	ctx := context.Background()
+	key := "test-mutex-min-retry-delay"
+
+	holder := r.NewMutex(key)
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+	defer holder.Unlock(ctx)
+
+	client := mockRedisClient()
+	hook := &attemptTimesHook{cmd: "set"}
+	client.AddHook(hook)
+	const floor = 50 * time.Millisecond
+	waiter := New(client).NewMutex(key,
+		WithRetryDelayFunc(func(tries int) time.Duration { return 0 }),
+		WithMinRetryDelay(floor),
+	)
+	waitCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	if err := waiter.Lock(waitCtx); err == nil {
+		t.Fatal("expected the waiter to time out while the lock is held")
+	}
+
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	if len(hook.times) < 4 {
+		t.Fatalf("expected several attempts, got %d", len(hook.times))
+	}
+	// The second attempt checks the lock right after subscribing.
+	for i := 2; i < len(hook.times); i++ {
+		// Allow for scheduling jitter between the timer and the command.
+		if gap := hook.times[i].Sub(hook.times[i-1]); gap < floor-5*time.Millisecond {
+			t.Errorf("expected no retry faster than %v, got %v", floor, gap)
+		}
+	}
+}
+
+func TestPSLock_LockAnyPollOnly(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	keys := []string{"test-pslock-lock-any-poll-1", "test-pslock-lock-any-poll-2"}
+
+	for _, key := range keys {
+		holder := r.NewMutex(key)
+		if err := holder.Lock(ctx); err != nil {
+			t.Fatalf("holder failed to acquire lock: %v", err)
+		}
+		defer holder.Unlock(ctx)
+	}
+
+	// The forwarding of a nil subscription channel ends with LockAny.
+	before := runtime.NumGoroutine()
+	for range 20 {
+		shortCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		_, err := r.LockAny(shortCtx, keys, WithNotifier(PollOnlyNotifier{}), WithRetryDelay(5*time.Millisecond))
+		cancel()
+		if err == nil {
+			t.Fatal("expected LockAny to fail while all locks are held")
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if leaked := runtime.NumGoroutine() - before; leaked >= 10 {
+		t.Errorf("expected no goroutines left behind, got %d more", leaked)
+	}
+}
+
+func TestPSLock_LockAny(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	keys := []string{"test-pslock-lock-any-1", "test-pslock-lock-any-2", "test-pslock-lock-any-3"}
+	for _, key := range keys {
+		client.Del(ctx, lockPrefix+key)
+	}
+
+	holders := make([]*Mutex, len(keys))
+	for i, key := range keys {
+		holders[i] = r.NewMutex(key)
+		if err := holders[i].Lock(ctx); err != nil {
+			t.Fatalf("holder failed to acquire lock %q: %v", key, err)
+		}
+	}
+
+	// Only the unlock message wakes the wait in time.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		holders[1].Unlock(ctx)
+	}()
+	start := time.Now()
+	m, err := r.LockAny(ctx, keys, WithRetryDelay(10*time.Second))
+	if err != nil {
+		t.Fatalf("lock any failed: %v", err)
+	}
+	if m.Key() != keys[1] {
+		t.Errorf("expected the released lock %q, got %q", keys[1], m.Key())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected to be woken by the unlock, took %v", elapsed)
+	}
+	if err := m.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+
+	// A free lock is taken right away.
+	m, err = r.LockAny(ctx, keys)
+	if err != nil || m.Key() != keys[1] {
+		t.Fatalf("expected the free lock %q, got %v", keys[1], err)
+	}
+	m.Unlock(ctx)
+
+	shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	holders[1].Lock(ctx)
+	if _, err := r.LockAny(shortCtx, keys); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("expected ErrLockTimeout while all locks are held, got %v", err)
+	}
+	for _, h := range holders {
+		h.Unlock(ctx)
+	}
+}
+
+// recordingSink is an EventSink delivering the events on a channel.
+type recordingSink struct {
+	events chan LockEvent
+}
+
+func (s *recordingSink) Emit(e LockEvent) {
+	s.events <- e
+}
+
+func TestMutex_EventSink(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	defer r.Close()
+	ctx := context.Background()
+	key := "test-mutex-event-sink"
+	client.Del(ctx, lockPrefix+key)
+
+	sink := &recordingSink{events: make(chan LockEvent, 16)}
+	victim := r.NewMutex(key, WithName("victim"), WithEventSink(sink), WithStealable())
+	if err := victim.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	token := victim.value
+	if err := victim.Extend(ctx); err != nil {
+		t.Fatalf("extend failed: %v", err)
+	}
+	if err := victim.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	victim.Lock(ctx)
+	thief := r.NewMutex(key, WithName("thief"), WithEventSink(sink), WithPriority(1))
+	if ok, err := thief.StealLock(ctx); !ok || err != nil {
+		t.Fatalf("steal failed: %v, %v", ok, err)
+	}
+	if err := r.ForceUnlock(ctx, key); err != nil {
+		t.Fatalf("force unlock failed: %v", err)
+	}
+
+	want := []LockEvent{
+		{Key: key, Event: EventAcquired, Holder: "victim", Token: token},
+		{Key: key, Event: EventExtended, Holder: "victim", Token: token},
+		{Key: key, Event: EventReleased, Holder: "victim", Token: token},
+		{Key: key, Event: EventAcquired, Holder: "victim"},
+		{Key: key, Event: EventAcquired, Holder: "thief"},
+		{Key: key, Event: EventStolen, Holder: "thief"},
+		{Key: key, Event: EventForceUnlocked},
+	}
+	for _, w := range want {
+		select {
+		case got := <-sink.events:
+			if got.Time.IsZero() {
+				t.Errorf("expected the %s event to carry a time", got.Event)
+			}
+			if w.Token == "" && got.Event != EventForceUnlocked && got.Token == "" {
+				t.Errorf("expected the %s event to carry a token", got.Event)
+			}
+			got.Time = time.Time{}
+			if w.Token == "" {
+				got.Token = ""
+			}
+			if got != w {
+				t.Errorf("expected event %+v, got %+v", w, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected event %+v", w)
+		}
+	}
+}
+
+// funcSink is an EventSink that cannot be used as a map key.
+type funcSink func(LockEvent)
+
+func (f funcSink) Emit(e LockEvent) {
+	f(e)
+}
+
+func TestMutex_EventSinkFunc(t *testing.T) {
+	r := New(mockRedisClient())
+	defer r.Close()
+	ctx := context.Background()
+	key := "test-mutex-event-sink-func"
+
+	events := make(chan LockEvent, 4)
+	sink := WithEventSink(funcSink(func(e LockEvent) { events <- e }))
+	first := r.NewMutex(key, sink)
+	second := r.NewMutex(key, sink)
+	if first.sinkQueue != second.sinkQueue {
+		t.Error("expected the mutexes of one option to share the queue")
+	}
+	if err := first.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	first.Unlock(ctx)
+	select {
+	case e := <-events:
+		if e.Event != EventAcquired {
+			t.Errorf("expected an acquired event, got %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the func sink to receive the event")
+	}
+}
+
+func TestMutex_ClockSkew(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	key := "test-mutex-clock-skew"
+	client.Del(ctx, lockPrefix+key)
+
+	const expiry, skew = 400 * time.Millisecond, 300 * time.Millisecond
+	raw := r.NewMutex(key+"-raw", WithExpiry(expiry))
+	skewed := r.NewMutex(key, WithExpiry(expiry), WithClockSkew(skew))
+	for _, m := range []*Mutex{raw, skewed} {
+		if err := m.Lock(ctx); err != nil {
+			t.Fatalf("lock failed: %v", err)
+		}
+		done := time.Now()
+		defer m.Unlock(ctx)
+		// The estimate counts from before the acquisition was sent.
+		if m == raw && !raw.Until().Before(done.Add(expiry)) {
+			t.Errorf("expected Until before %v after Lock returned, got %v", expiry, raw.Until().Sub(done))
+		}
+	}
+	if diff := raw.Until().Sub(skewed.Until()); diff < skew-50*time.Millisecond || diff > skew+50*time.Millisecond {
+		t.Errorf("expected Until to be %v earlier, got %v", skew, diff)
+	}
+	if ttl, err := skewed.TTL(ctx); err != nil || ttl > expiry-skew {
+		t.Errorf("expected the TTL less the skew, got %v, %v", ttl, err)
+	}
+
+	// Past the margin the lock is still in Redis but no longer safe.
+	time.Sleep(150 * time.Millisecond)
+	if ok, err := raw.Valid(ctx); err != nil || !ok {
+		t.Errorf("expected the lock without skew to be valid, got %v, %v", ok, err)
+	}
+	if ok, err := skewed.Valid(ctx); err != nil || ok {
+		t.Errorf("expected the lock to be unsafe within the skew of its expiry, got %v, %v", ok, err)
+	}
+	if n, _ := client.Exists(ctx, skewed.getKey()).Result(); n != 1 {
+		t.Error("expected the skewed lock to still exist in Redis")
+	}
+
+	if err := skewed.Extend(ctx); err != nil {
+		t.Fatalf("extend failed: %v", err)
+	}
+	if ok, err := skewed.Valid(ctx); err != nil || !ok {
+		t.Errorf("expected the extended lock to be valid again, got %v, %v", ok, err)
+	}
+
+	// A rotation writes a new lease and moves the estimate with it.
+	before := raw.Until()
+	time.Sleep(50 * time.Millisecond)
+	if err := raw.RotateToken(ctx); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	if !raw.Until().After(before.Add(40 * time.Millisecond)) {
+		t.Errorf("expected the rotation to renew Until, got %v after %v", raw.Until(), before)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a negative clock skew")
+		}
+	}()
+	WithClockSkew(-time.Second)
+}
+
+func TestMutex_AutoUnlockOnCancel(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	key := "test-mutex-auto-unlock-on-cancel"
+	client.Del(ctx, lockPrefix+key)
+
+	logger := &bufferLogger{}
+	mutex := r.NewMutex(key, WithAutoUnlockOnCancel(), WithLogger(logger))
+	reqCtx, cancel := context.WithCancel(ctx)
+	if err := mutex.Lock(reqCtx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		n, _ := client.Exists(ctx, mutex.getKey()).Result()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the lock to be released once the context is cancelled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !mutex.Until().IsZero() {
+		t.Error("expected the automatic release to clear the hold locally")
+	}
+
+	// An Unlock first stops the automatic release of the hold.
+	reqCtx, cancel = context.WithCancel(ctx)
+	if err := mutex.Lock(reqCtx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	other := r.NewMutex(key)
+	if err := other.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer other.Unlock(ctx)
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 1 {
+		t.Error("expected the cancel after Unlock not to release the lock again")
+	}
+	if strings.Contains(logger.String(), "failed") {
+		t.Errorf("expected no double unlock, got log %q", logger.String())
+	}
+}
+
+func TestPSLock_InspectMany(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	keys := []string{"test-pslock-inspect-many-1", "test-pslock-inspect-many-2", "test-pslock-inspect-many-3"}
+	for _, key := range keys {
+		client.Del(ctx, lockPrefix+key)
+	}
+
+	held := r.NewMutex(keys[0], WithExpiry(5*time.Second))
+	if err := held.Lock(ctx); err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	defer held.Unlock(ctx)
+	client.Set(ctx, lockPrefix+keys[2], "stuck", 0)
+	defer client.Del(ctx, lockPrefix+keys[2])
+
+	infos, err := r.InspectMany(ctx, keys)
+	if err != nil {
+		t.Fatalf("inspect failed: %v", err)
+	}
+	if len(infos) != len(keys) {
+		t.Fatalf("expected a state for every key, got %v", infos)
+	}
+	if info := infos[keys[0]]; !info.Held || info.Token != held.value || info.TTL <= 0 || info.TTL > 5*time.Second {
+		t.Errorf("expected the held lock with its token and TTL, got %+v", info)
+	}
+	if info := infos[keys[1]]; info.Held || info.Key != keys[1] {
+		t.Errorf("expected the missing lock not to be held, got %+v", info)
+	}
+	if info := infos[keys[2]]; !info.Held || info.TTL != -1 {
+		t.Errorf("expected the lock without expiry to have TTL -1, got %+v", info)
+	}
+}
+
+func TestMutex_AcquireProvisional(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	key := "test-mutex-acquire-provisional"
+	client.Del(ctx, lockPrefix+key)
+
+	mutex := r.NewMutex(key, WithExpiry(10*time.Second), WithProvisionalExpiry(500*time.Millisecond))
+	commit, _, err := mutex.AcquireProvisional(ctx)
+	if err != nil {
+		t.Fatalf("provisional acquire failed: %v", err)
+	}
+	ttl, _ := client.PTTL(ctx, mutex.getKey()).Result()
+	if ttl <= 0 || ttl > 500*time.Millisecond {
+		t.Fatalf("expected the provisional expiry, got TTL %v", ttl)
+	}
+	if err := commit(ctx); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	ttl, _ = client.PTTL(ctx, mutex.getKey()).Result()
+	if ttl <= 500*time.Millisecond || ttl > 10*time.Second {
+		t.Errorf("expected commit to extend to the full expiry, got TTL %v", ttl)
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+
+	_, abort, err := mutex.AcquireProvisional(ctx)
+	if err != nil {
+		t.Fatalf("provisional acquire failed: %v", err)
+	}
+	if err := abort(ctx); err != nil {
+		t.Fatalf("abort failed: %v", err)
+	}
+	if n, _ := client.Exists(ctx, mutex.getKey()).Result(); n != 0 {
+		t.Error("expected abort to release the lock")
+	}
+	if err := abort(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld on a second abort, got %v", err)
+	}
+
+	// A provisional hold that is neither committed nor aborted expires.
+	commit, _, err = mutex.AcquireProvisional(ctx)
+	if err != nil {
+		t.Fatalf("provisional acquire failed: %v", err)
+	}
+	client.PExpire(ctx, mutex.getKey(), time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if err := commit(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld committing an expired hold, got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a provisional expiry of 0")
+		}
+	}()
+	WithProvisionalExpiry(0)
+}
+
+func TestMutex_AcquireProvisionalAutoRenew(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	key := "test-mutex-acquire-provisional-auto-renew"
+	client.Del(ctx, lockPrefix+key)
+
+	lost := make(chan error, 1)
+	mutex := r.NewMutex(key, WithExpiry(3*time.Second), WithProvisionalExpiry(300*time.Millisecond), WithAutoRenew(),
+		WithOnLost(func(ctx context.Context, m *Mutex, err error) { lost <- err }))
+	commit, _, err := mutex.AcquireProvisional(ctx)
+	if err != nil {
+		t.Fatalf("provisional acquire failed: %v", err)
+	}
+
+	// The watchdog keeps the provisional hold alive at its own expiry.
+	time.Sleep(700 * time.Millisecond)
+	ttl, _ := client.PTTL(ctx, mutex.getKey()).Result()
+	if ttl <= 0 || ttl > 300*time.Millisecond {
+		t.Fatalf("expected the provisional hold to be renewed at the provisional expiry, got TTL %v", ttl)
+	}
+	if err := commit(ctx); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	ttl, _ = client.PTTL(ctx, mutex.getKey()).Result()
+	if ttl <= 300*time.Millisecond {
+		t.Errorf("expected the committed hold to be renewed at the full expiry, got TTL %v", ttl)
+	}
+	if err := mutex.Unlock(ctx); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	select {
+	case err := <-lost:
+		t.Errorf("expected the hold not to be lost, got %v", err)
+	default:
+	}
+}
+
+func TestMutex_ExpiredEvents(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-mutex-expired-events"
+	client.Del(ctx, lockPrefix+name)
+
+	// The holder never unlocks.
+	holder := r.NewMutex(name, WithExpiry(300*time.Millisecond))
+	if err := holder.Lock(ctx); err != nil {
+		t.Fatalf("holder failed to acquire lock: %v", err)
+	}
+
+	waiter := r.NewMutex(name, WithExpiredEvents(), WithRetryDelay(5*time.Second))
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		done <- waiter.Lock(ctx)
+	}()
+
+	// Play the part of Redis, which publishes the event on expiry if
+	// keyspace notifications are enabled.
+	for {
+		if n, _ := client.Exists(ctx, lockPrefix+name).Result(); n == 0 {
+			break
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("expected the lock of the holder to expire")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	client.Publish(ctx, "__keyevent@0__:expired", lockPrefix+"test-mutex-expired-events-other")
+	client.Publish(ctx, "__keyevent@0__:expired", lockPrefix+name)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waiter failed to acquire lock: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the waiter to acquire the lock on the expired event before its next retry")
+	}
+	if err := waiter.Unlock(ctx); err != nil {
+		t.Errorf("unlock failed: %v", err)
+	}
+}
+
+func TestSubscriptionMux_StaleConfirmation(t *testing.T) {
+	m := newSubscriptionMux(mockRedisClient(), 1)
+	defer m.close()
+	s := m.shards[0]
+	channel := "test-subscription-mux-stale-confirmation"
+	msgs := make(chan any)
+	defer close(msgs)
+	go s.run(msgs)
+
+	// A waiter left before the reply to its SUBSCRIBE arrived, and another
+	// one joined the channel with a SUBSCRIBE of its own.
+	waiter := &muxSubscription{shard: s, channel: channel, payloads: make(chan string, 1), confirmed: make(chan struct{})}
+	s.mu.Lock()
+	s.subs[channel] = map[*muxSubscription]struct{}{waiter: {}}
+	s.pending[channel] = map[*muxSubscription]struct{}{waiter: {}}
+	s.inflight[channel] = 2
+	s.mu.Unlock()
+
+	// Each send waits for run to be done with the previous message.
+	msgs <- &redis.Subscription{Kind: "subscribe", Channel: channel}
+	msgs <- &redis.Subscription{Kind: "unsubscribe", Channel: channel}
+	select {
+	case <-waiter.confirmed:
+		t.Fatal("expected the reply to the earlier SUBSCRIBE not to confirm the waiter")
+	default:
+	}
+	msgs <- &redis.Subscription{Kind: "subscribe", Channel: channel}
+	select {
+	case <-waiter.confirmed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the reply to its own SUBSCRIBE to confirm the waiter")
+	}
+}
+
+func TestSubscriptionMux_Close(t *testing.T) {
+	m := newSubscriptionMux(mockRedisClient(), 1)
+	sub, err := m.Subscribe(context.Background(), "test-subscription-mux-close")
+	if err != nil {
+		t.Fatalf("subscribe failed: %v", err)
+	}
+
+	m.close()
+	select {
+	case _, ok := <-sub.Channel():
+		if ok {
+			t.Fatal("expected no message")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected close to close the channel of the subscription")
+	}
+	if err := sub.Close(); err != nil {
+		t.Errorf("expected closing the subscription after close to succeed, got %v", err)
+	}
+}
+
+func TestSubscriptionMux_SubscribeFailure(t *testing.T) {
+	m := newSubscriptionMux(mockRedisClient(), 1)
+	defer m.close()
+	channel := "test-subscription-mux-failure"
+
+	// A failed SUBSCRIBE leaves nothing of the waiter behind.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := m.Subscribe(cancelled, channel); err == nil {
+		t.Fatal("expected the subscription to fail with a cancelled context")
+	}
+	s := m.shards[0]
+	s.mu.Lock()
+	left := len(s.subs) + len(s.pending)
+	s.mu.Unlock()
+	if left != 0 {
+		t.Errorf("expected the failed waiter to be unregistered, got %d entries", left)
+	}
+
+	sub, err := m.Subscribe(context.Background(), channel)
+	if err != nil {
+		t.Fatalf("expected a later subscription to succeed, got %v", err)
+	}
+	if err := m.Publish(context.Background(), channel, unlockPayload); err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+	select {
+	case <-sub.Channel():
+	case <-time.After(time.Second):
+		t.Error("expected the later subscription to receive messages")
+	}
+	sub.Close()
+
+	m.close()
+	if _, err := m.Subscribe(context.Background(), channel); !errors.Is(err, redis.ErrClosed) {
+		t.Errorf("expected redis.ErrClosed after close, got %v", err)
+	}
+}
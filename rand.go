@@ -0,0 +1,32 @@
+package pslock
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// lockedSource makes a rand.Source safe for concurrent use, so that a
+// source can back the retry delays of a mutex locked from several
+// goroutines, or be shared by the mutexes of WithRandSource.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// newRand returns a generator of its own for a mutex, seeded from the
+// global one.
+func newRand() *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(rand.Int63())})
+}
@@ -0,0 +1,308 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// extendScript resets the expiry of the lock only if it still holds our
+// value. A lock still holding ARGV[3], the token replaced by a rotation,
+// is committed to our value. It is mirrored in functionLibrary.
+var extendScript = redis.NewScript(`
+local v = redis.call("GET", KEYS[1])
+if v == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if ARGV[3] ~= "" and v == ARGV[3] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// Extend resets the expiry of the held lock to the mutex expiry. It returns
+// ErrLockNotHeld if the key is gone or held by someone else, and
+// ErrStaleEpoch for a lock of WithEpoch acquired before BumpEpoch.
+func (dl *Mutex) Extend(ctx context.Context) error {
+	if ctx == nil {
+		return fmt.Errorf("%w: %q", ErrNilContext, dl.key)
+	}
+	dl.mu.Lock()
+	value := dl.value
+	dl.mu.Unlock()
+	return dl.extend(ctx, value)
+}
+
+// ExtendAsync extends the held lock like Extend in the background and
+// delivers the single result on the returned channel, so that the caller
+// can go on and check it later. The hold is captured when ExtendAsync is
+// called, so a later Unlock or Lock does not change what is extended. The
+// channel is buffered, so the extension never blocks on a caller that
+// does not read it, and it ends with ctx at the latest.
+func (dl *Mutex) ExtendAsync(ctx context.Context) <-chan error {
+	result := make(chan error, 1)
+	if ctx == nil {
+		result <- fmt.Errorf("%w: %q", ErrNilContext, dl.key)
+		return result
+	}
+	dl.mu.Lock()
+	value := dl.value
+	dl.mu.Unlock()
+	go func() {
+		result <- dl.extend(ctx, value)
+	}()
+	return result
+}
+
+func (dl *Mutex) extend(ctx context.Context, value string) error {
+	if err := dl.checkEpoch(ctx); err != nil {
+		return err
+	}
+	dl.mu.Lock()
+	value, previous := dl.tokensFor(value)
+	expiry := dl.renewalExpiry()
+	dl.mu.Unlock()
+
+	start := time.Now()
+	n, err := withOpTimeout(ctx, dl, func(ctx context.Context) (int, error) {
+		return dl.runScript(ctx, extendScript, "pslock_extend", []string{dl.getKey()}, value, expiry.Milliseconds(), previous).Int()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to extend lock %q: %w", dl.key, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %q", ErrLockNotHeld, dl.key)
+	}
+	dl.emit(EventExtended, value)
+	dl.refreshIntent(ctx, value, expiry)
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.value == value {
+		dl.heldExpiry = expiry
+		dl.until = start.Add(expiry)
+		if dl.lapseTimer != nil {
+			dl.lapseTimer.Reset(expiry)
+		}
+	}
+	return nil
+}
+
+// renewOrReacquireScript extends the lock like extendScript and returns 1
+// if it still holds our value, and otherwise takes it again with our value
+// if it is free and returns 2.
+var renewOrReacquireScript = redis.NewScript(`
+local v = redis.call("GET", KEYS[1])
+if v == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+if ARGV[3] ~= "" and v == ARGV[3] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+if not v then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 2
+end
+return 0
+`)
+
+// RenewOrReacquire extends the held lock like Extend, or takes it again
+// if it expired and is free, in a single round trip, e.g. for self-healing
+// renewal loops. reacquired reports the latter: the lock was not held for
+// a while and another holder may have acted in the gap, so work relying
+// on uninterrupted exclusivity must be checked or redone. It returns
+// ErrLockNotHeld if the mutex does not hold the lock or another holder
+// has taken it, and ErrStaleEpoch like Extend.
+func (dl *Mutex) RenewOrReacquire(ctx context.Context) (reacquired bool, err error) {
+	dl.mu.Lock()
+	value, previous := dl.tokensFor(dl.value)
+	expiry := dl.renewalExpiry()
+	dl.mu.Unlock()
+	if value == "" {
+		return false, fmt.Errorf("%w: %q", ErrLockNotHeld, dl.key)
+	}
+	if err := dl.checkEpoch(ctx); err != nil {
+		return false, err
+	}
+
+	start := time.Now()
+	n, err := withOpTimeout(ctx, dl, func(ctx context.Context) (int, error) {
+		return renewOrReacquireScript.Run(ctx, dl.client, []string{dl.getKey()}, value, expiry.Milliseconds(), previous).Int()
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to renew lock %q: %w", dl.key, err)
+	}
+	if n == 0 {
+		return false, fmt.Errorf("%w: %q", ErrLockNotHeld, dl.key)
+	}
+	if n == 2 {
+		dl.emit(EventAcquired, value)
+	} else {
+		dl.emit(EventExtended, value)
+	}
+	dl.refreshIntent(ctx, value, expiry)
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.value == value {
+		dl.heldExpiry = expiry
+		dl.until = start.Add(expiry)
+		if dl.lapseTimer != nil {
+			dl.lapseTimer.Reset(expiry)
+		}
+	}
+	return n == 2, nil
+}
+
+// renewalExpiry returns the expiry an extension resets the lock to: the
+// one chosen at acquisition with WithAdaptiveExpiry or for an uncommitted
+// hold of AcquireProvisional, and the mutex expiry otherwise. dl.mu must
+// be held.
+func (dl *Mutex) renewalExpiry() time.Duration {
+	if (dl.adaptiveExpiryMax > 0 || dl.heldProvisional) && dl.heldExpiry > 0 {
+		return dl.heldExpiry
+	}
+	return dl.expiry
+}
+
+// ReportProgress tells the renewal watchdog of WithAutoRenew how far the
+// job under the lock is, from 0 at the start to 1 when it is done, so that
+// it extends less often as the job nears completion. The watchdog extends
+// every third of the expiry at the start, leaving room for two failed
+// extensions, and tapers linearly to every half of the expiry at 1, where
+// a single failed extension is still covered. The new cadence applies from
+// the next extension. Reports outside [0, 1] are clamped, and the progress
+// is reset on each acquisition. Without reports the cadence is unchanged.
+func (dl *Mutex) ReportProgress(fraction float64) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.progress = min(max(fraction, 0), 1)
+}
+
+// Touch records activity of the holder for WithIdleTimeout. It does not
+// call Redis and returns ErrLockNotHeld if the mutex holds no lock.
+func (dl *Mutex) Touch(ctx context.Context) error {
+	if ctx == nil {
+		return fmt.Errorf("%w: %q", ErrNilContext, dl.key)
+	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.value == "" {
+		return fmt.Errorf("%w: %q", ErrLockNotHeld, dl.key)
+	}
+	dl.touched = time.Now()
+	return nil
+}
+
+// idle reports whether the holder went without Touch for longer than the
+// idle timeout.
+func (dl *Mutex) idle() bool {
+	if dl.idleTimeout <= 0 {
+		return false
+	}
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return time.Since(dl.touched) > dl.idleTimeout
+}
+
+// renewInterval returns the time between two extensions of the watchdog
+// for expiry at the reported progress.
+func (dl *Mutex) renewInterval(expiry time.Duration) time.Duration {
+	dl.mu.Lock()
+	progress := dl.progress
+	dl.mu.Unlock()
+	// Clock skew eats into the lease the watchdog can rely on.
+	if expiry > dl.clockSkew {
+		expiry -= dl.clockSkew
+	}
+	return time.Duration(float64(expiry) * (1.0/3 + progress/6))
+}
+
+// watchdog extends the hold with value every third of its expiry, or less
+// often with ReportProgress, until ctx is cancelled on release or the
+// holder went idle, and reports the lock lost once renewing fails. The
+// first extension is timed by expiry, the lease of the acquisition, and
+// the following ones by the expiry each extension set.
+func (dl *Mutex) watchdog(ctx context.Context, value string, expiry time.Duration) {
+	timer := time.NewTimer(dl.renewInterval(expiry))
+	defer timer.Stop()
+	extended := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if dl.idle() {
+			// Let the lock expire in Redis.
+			dl.logger.Printf("pslock: lock %q idle for more than %v, no longer renewing", dl.name, dl.idleTimeout)
+			return
+		}
+		start := time.Now()
+		if err := dl.renew(ctx, value, extended.Add(expiry)); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			dl.lost(value, err)
+			return
+		}
+		extended = start
+		dl.mu.Lock()
+		if dl.value == value {
+			expiry = dl.heldExpiry
+		}
+		dl.mu.Unlock()
+		timer.Reset(dl.renewInterval(expiry))
+	}
+}
+
+// renew extends the hold with value. Errors other than a lost lock, such
+// as a brief Redis outage, are retried with backoff as long as the last
+// extension keeps the lock alive, i.e. until deadline. A retry of an
+// extension whose reply was lost is harmless, as extendScript accepts the
+// lock it already extended.
+func (dl *Mutex) renew(ctx context.Context, value string, deadline time.Time) error {
+	delay := transientRetryBaseDelay
+	for {
+		err := dl.extend(ctx, value)
+		if err == nil || errors.Is(err, ErrLockNotHeld) || errors.Is(err, ErrStaleEpoch) || time.Until(deadline) <= delay {
+			return err
+		}
+		dl.logger.Printf("pslock: renewing lock %q failed, retrying: %v", dl.name, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, transientRetryMaxDelay)
+	}
+}
+
+// lost reports the loss of the hold with value once: it logs err, cancels
+// the context returned by LockWithRelease and invokes the lost callback.
+func (dl *Mutex) lost(value string, err error) {
+	dl.mu.Lock()
+	if dl.value != value || dl.lostReported {
+		dl.mu.Unlock()
+		return
+	}
+	dl.lostReported = true
+	cancel := dl.lostCancel
+	dl.mu.Unlock()
+
+	dl.logger.Printf("pslock: WARNING lock %q was lost while held, another holder may own it: %v", dl.name, err)
+	dl.emit(EventLost, value)
+	if cancel != nil {
+		cancel()
+	}
+	if dl.onLost != nil {
+		dl.onLost(context.Background(), dl, err)
+	}
+}
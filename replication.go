@@ -0,0 +1,66 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrReplicationLag is returned by Lock when the replicas of the Redis
+// primary do not acknowledge an acquisition within WithMaxReplicationLag.
+var ErrReplicationLag = errors.New("redis replication lag too high")
+
+// connectedReplicas returns the number of replicas connected to the Redis
+// server as reported by INFO replication, 0 on a replica or a standalone
+// server.
+func connectedReplicas(ctx context.Context, c redis.Cmdable) (int, error) {
+	info, err := c.Info(ctx, "replication").Result()
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "connected_slaves:"); ok {
+			return strconv.Atoi(v)
+		}
+	}
+	return 0, nil
+}
+
+// waitCmdable is implemented by redis.Client and redis.Conn, although
+// WAIT is not part of redis.Cmdable.
+type waitCmdable interface {
+	Wait(ctx context.Context, numSlaves int, timeout time.Duration) *redis.IntCmd
+}
+
+// confirmReplicated waits for all connected replicas to acknowledge the
+// acquisition of the lease on c within the maximum replication lag. If
+// they do not, the acquisition is undone and ErrReplicationLag is
+// returned. A resumed hold of the owner ID predates the acquisition and
+// is kept.
+func (dl *Mutex) confirmReplicated(ctx context.Context, c redis.Cmdable, l lease, resumed bool) error {
+	n, err := connectedReplicas(ctx, c)
+	if err == nil && n > 0 {
+		var acked int64
+		acked, err = c.(waitCmdable).Wait(ctx, n, dl.maxReplicationLag).Result()
+		if err == nil && acked < int64(n) {
+			err = fmt.Errorf("%w: %q: %d of %d replicas acknowledged within %v", ErrReplicationLag, dl.key, acked, n, dl.maxReplicationLag)
+		}
+	}
+	if err == nil || resumed {
+		return err
+	}
+
+	// Undo the acquisition and wake up the waiters it may have kept.
+	ctx = context.WithoutCancel(ctx)
+	if err := unlockScript.Run(ctx, c, []string{dl.getKey()}, l.value).Err(); err != nil {
+		dl.logger.Printf("pslock: failed to undo acquisition of lock %q: %v", dl.key, err)
+	} else if err := dl.getNotifier().Publish(ctx, dl.getKey(), unlockPayload); err != nil {
+		dl.logger.Printf("pslock: failed to publish unlock message for lock %q: %v", dl.key, err)
+	}
+	return err
+}
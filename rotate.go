@@ -0,0 +1,112 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// rotationGrace is how long Unlock and Extend keep accepting the token
+// replaced by RotateToken.
+const rotationGrace = time.Second
+
+// rotateScript replaces our value ARGV[2], or ARGV[3] left from a previous
+// rotation, by ARGV[1] and resets the expiry to ARGV[4] milliseconds. A
+// lock already holding ARGV[1] was committed to it by a concurrent Extend.
+var rotateScript = redis.NewScript(`
+local v = redis.call("GET", KEYS[1])
+if v == ARGV[1] or v == ARGV[2] or (ARGV[3] ~= "" and v == ARGV[3]) then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[4])
+	return 1
+end
+return 0
+`)
+
+// RotateToken replaces the token of the held lock by a fresh one, e.g. to
+// limit the exposure of a token during a long hold, and resets the expiry.
+// Unlock and Extend issued around the rotation may still carry the old
+// token, so both tokens are accepted for a short grace, with the lock
+// committed to the new one. If the rotation fails, the old token stays
+// current and the new one is accepted for the grace, so that RotateToken
+// can be retried.
+//
+// It returns ErrLockNotHeld if the lock is not held. Mutexes with
+// WithOwnerID or WithIntentParent cannot rotate their token, as it is
+// bound to the owner or recorded on the parent.
+func (dl *Mutex) RotateToken(ctx context.Context) error {
+	if dl.ownerID != "" || dl.intentParent != "" {
+		return fmt.Errorf("failed to rotate token of lock %q: token is bound to the owner or intent parent", dl.key)
+	}
+	l, err := dl.newLease(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to rotate token of lock %q: %w", dl.key, err)
+	}
+
+	dl.mu.Lock()
+	old := dl.value
+	if old == "" {
+		dl.mu.Unlock()
+		return fmt.Errorf("%w: %q", ErrLockNotHeld, dl.key)
+	}
+	_, earlier := dl.tokensFor(old)
+	dl.value = l.value
+	dl.prevValue, dl.prevUntil = old, time.Now().Add(rotationGrace)
+	dl.mu.Unlock()
+	dl.pslock.retrack(dl, old, l.value)
+
+	start := time.Now()
+	n, err := withOpTimeout(ctx, dl, func(ctx context.Context) (int, error) {
+		return rotateScript.Run(ctx, dl.client, []string{dl.getKey()}, l.value, old, earlier, l.expiry.Milliseconds()).Int()
+	})
+	if err != nil || n == 0 {
+		// Keep the old token, accepting the new one in case the reply
+		// got lost after the rotation was applied.
+		dl.mu.Lock()
+		if dl.value == l.value {
+			dl.value, dl.prevValue = old, l.value
+		}
+		dl.mu.Unlock()
+		dl.pslock.retrack(dl, l.value, old)
+		if err != nil {
+			return fmt.Errorf("failed to rotate token of lock %q: %w", dl.key, err)
+		}
+		return fmt.Errorf("%w: %q", ErrLockNotHeld, dl.key)
+	}
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.value != l.value {
+		// Unlocked meanwhile
+		return nil
+	}
+	dl.heldExpiry = l.expiry
+	dl.until = start.Add(l.expiry)
+	// The renewal watchdog and the lapse timer follow the new token.
+	if dl.renewCancel != nil {
+		dl.renewCancel()
+		var renewCtx context.Context
+		renewCtx, dl.renewCancel = context.WithCancel(context.Background())
+		go dl.watchdog(renewCtx, l.value, dl.renewalExpiry())
+	}
+	if dl.lapseTimer != nil {
+		dl.lapseTimer.Stop()
+		value := l.value
+		dl.lapseTimer = time.AfterFunc(l.expiry, func() { dl.expiryLapsed(value) })
+	}
+	return nil
+}
+
+// tokensFor maps value, a token of the current hold, to the current token
+// and the token replaced by the last rotation while it is still accepted.
+// Other values map to themselves. dl.mu must be held.
+func (dl *Mutex) tokensFor(value string) (current, previous string) {
+	if value == "" || dl.prevValue == "" || !time.Now().Before(dl.prevUntil) {
+		return value, ""
+	}
+	if value != dl.value && value != dl.prevValue {
+		return value, ""
+	}
+	return dl.value, dl.prevValue
+}
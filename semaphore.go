@@ -0,0 +1,212 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	semaphorePrefix = "distributed_semaphore:"
+)
+
+// acquireSemaphoreScript prunes expired holders and adds ARGV[1] to the
+// holder set if fewer than ARGV[2] holders remain. Scores are the holder
+// deadlines in milliseconds of Redis server time.
+var acquireSemaphoreScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+if redis.call("ZCARD", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("ZADD", KEYS[1], now + tonumber(ARGV[3]), ARGV[1])
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// acquireSemaphoreNScript is acquireSemaphoreScript for up to ARGV[4]
+// permits at once, added as ARGV[1] suffixed by their index. It returns
+// the number of permits granted.
+var acquireSemaphoreNScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+local n = math.min(tonumber(ARGV[2]) - redis.call("ZCARD", KEYS[1]), tonumber(ARGV[4]))
+if n <= 0 then
+	return 0
+end
+for i = 1, n do
+	redis.call("ZADD", KEYS[1], now + tonumber(ARGV[3]), ARGV[1] .. ":" .. i)
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return n
+`)
+
+// Semaphore represents a distributed counting semaphore that allows up to
+// limit concurrent holders. Each Semaphore value holds the permit of
+// Acquire or the permits granted by TryAcquireN.
+type Semaphore struct {
+	pslock *PSLock
+	client *redis.Client
+	// The maximum waiting time if no permit is obtained
+	patient time.Duration
+	key     string
+	limit   int
+	expiry  time.Duration
+
+	tries       int
+	delayFunc   DelayFunc
+	notifier    Notifier
+	keyEncoding KeyEncoding
+
+	tokens []string
+}
+
+// NewSemaphore returns a new distributed semaphore with given key that
+// admits up to limit concurrent holders. The expiry, tries, retry delay,
+// client, notifier and key encoding options apply to it like to a mutex;
+// an expired holder frees its permit.
+func (r *PSLock) NewSemaphore(key string, limit int, options ...Option) *Semaphore {
+	m := r.NewMutex(key, options...)
+	return &Semaphore{
+		pslock:      r,
+		client:      m.client,
+		patient:     m.patient,
+		key:         key,
+		limit:       limit,
+		expiry:      m.expiry,
+		tries:       m.tries,
+		delayFunc:   m.delayFunc,
+		notifier:    m.getNotifier(),
+		keyEncoding: m.keyEncoding,
+	}
+}
+
+func (s *Semaphore) getKey() string {
+	return semaphorePrefix + s.keyEncoding.encode(s.key)
+}
+
+// Acquire obtains a permit, blocking until one is released if all of them
+// are currently held.
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	if s.pslock.draining.Load() {
+		return ErrDraining
+	}
+
+	token, err := genToken()
+	if err != nil {
+		return fmt.Errorf("failed to acquire semaphore %q: %w", s.key, err)
+	}
+
+	ok, err := s.tryAcquire(ctx, token)
+	if err != nil {
+		return fmt.Errorf("failed to acquire semaphore %q: %w", s.key, err)
+	}
+	if ok {
+		s.tokens = append(s.tokens, token)
+		return nil
+	}
+
+	// If all permits are held, enter blocking flow
+	return s.blockingAcquire(ctx, token)
+}
+
+func (s *Semaphore) tryAcquire(ctx context.Context, token string) (bool, error) {
+	n, err := acquireSemaphoreScript.Run(ctx, s.client, []string{s.getKey()},
+		token, s.limit, s.expiry.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// TryAcquireN obtains as many permits as are free, up to n, in a single
+// atomic step without waiting, e.g. to reserve resources for a bulk
+// operation. It returns the number of permits granted, which may be 0.
+// Release gives all of them back.
+func (s *Semaphore) TryAcquireN(ctx context.Context, n int) (int, error) {
+	if s.pslock.draining.Load() {
+		return 0, ErrDraining
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+
+	token, err := genToken()
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire semaphore %q: %w", s.key, err)
+	}
+	granted, err := acquireSemaphoreNScript.Run(ctx, s.client, []string{s.getKey()},
+		token, s.limit, s.expiry.Milliseconds(), n).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire semaphore %q: %w", s.key, err)
+	}
+	for i := 1; i <= granted; i++ {
+		s.tokens = append(s.tokens, fmt.Sprintf("%s:%d", token, i))
+	}
+	return granted, nil
+}
+
+// blockingAcquire waits for a release notification or the next retry to
+// try for a permit again.
+func (s *Semaphore) blockingAcquire(ctx context.Context, token string) error {
+	key := s.getKey()
+
+	// Subscribe to the semaphore channel for release notifications
+	sub, err := s.notifier.Subscribe(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to semaphore %q: %w", s.key, err)
+	}
+	defer sub.Close()
+
+	msgCh := sub.Channel()
+
+	// Create a context with timeout for the entire blocking operation
+	blockCtx, cancel := context.WithTimeout(ctx, s.patient)
+	defer cancel()
+
+	for i := range s.tries {
+		select {
+		case <-blockCtx.Done():
+			return fmt.Errorf("%w: semaphore %q", ErrLockTimeout, s.key)
+		case _, ok := <-msgCh:
+			if !ok {
+				// The notifier closed the subscription, keep polling
+				msgCh = nil
+			}
+		case <-time.After(s.delayFunc(i)):
+		}
+
+		ok, err := s.tryAcquire(blockCtx, token)
+		if err == nil && ok {
+			s.tokens = append(s.tokens, token)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: semaphore %q", ErrLockTimeout, s.key)
+}
+
+// Release gives the held permits back and notifies waiting holders.
+func (s *Semaphore) Release(ctx context.Context) error {
+	key := s.getKey()
+
+	if len(s.tokens) > 0 {
+		members := make([]any, len(s.tokens))
+		for i, token := range s.tokens {
+			members[i] = token
+		}
+		if _, err := s.client.ZRem(ctx, key, members...).Result(); err != nil {
+			return fmt.Errorf("failed to release semaphore %q: %w", s.key, err)
+		}
+		s.tokens = nil
+	}
+
+	// Publish release message to notify waiting goroutines
+	if err := s.notifier.Publish(ctx, key, "release"); err != nil {
+		return fmt.Errorf("failed to publish release message for semaphore %q: %w", s.key, err)
+	}
+	return nil
+}
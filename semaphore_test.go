@@ -0,0 +1,107 @@
+package pslock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSemaphore_LimitsConcurrentHolders(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-semaphore"
+	client.Del(ctx, semaphorePrefix+name)
+
+	first := r.NewSemaphore(name, 2)
+	second := r.NewSemaphore(name, 2)
+	if err := first.Acquire(ctx); err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+	if err := second.Acquire(ctx); err != nil {
+		t.Fatalf("second acquire failed: %v", err)
+	}
+
+	third := r.NewSemaphore(name, 2, WithRetryDelay(20*time.Millisecond))
+	shortCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if err := third.Acquire(shortCtx); err == nil {
+		t.Fatal("expected third acquire to block while both permits are held")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- third.Acquire(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := first.Release(ctx); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("third acquire failed after release: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected third acquire to succeed after release")
+	}
+	second.Release(ctx)
+	third.Release(ctx)
+}
+
+func TestSemaphore_ExpiredHolderFreesPermit(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-semaphore-expiry"
+	client.Del(ctx, semaphorePrefix+name)
+
+	crashed := r.NewSemaphore(name, 1, WithExpiry(100*time.Millisecond))
+	if err := crashed.Acquire(ctx); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+
+	// The crashed holder never releases; its permit frees on expiry.
+	waiter := r.NewSemaphore(name, 1, WithRetryDelay(50*time.Millisecond))
+	if err := waiter.Acquire(ctx); err != nil {
+		t.Fatalf("expected permit after holder expiry, got %v", err)
+	}
+	waiter.Release(ctx)
+}
+
+func TestSemaphore_TryAcquireN(t *testing.T) {
+	client := mockRedisClient()
+	r := New(client)
+	ctx := context.Background()
+	name := "test-semaphore-try-acquire-n"
+	client.Del(ctx, semaphorePrefix+name)
+
+	single := r.NewSemaphore(name, 5)
+	if err := single.Acquire(ctx); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+
+	bulk := r.NewSemaphore(name, 5)
+	n, err := bulk.TryAcquireN(ctx, 3)
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 permits, got %d, %v", n, err)
+	}
+	// Only one permit is left.
+	n, err = bulk.TryAcquireN(ctx, 3)
+	if err != nil || n != 1 {
+		t.Fatalf("expected the last permit, got %d, %v", n, err)
+	}
+	n, err = r.NewSemaphore(name, 5).TryAcquireN(ctx, 2)
+	if err != nil || n != 0 {
+		t.Fatalf("expected no permits while all are held, got %d, %v", n, err)
+	}
+
+	if err := bulk.Release(ctx); err != nil {
+		t.Fatalf("release failed: %v", err)
+	}
+	if held, _ := client.ZCard(ctx, semaphorePrefix+name).Result(); held != 1 {
+		t.Fatalf("expected the batch to be given back, got %d permits held", held)
+	}
+	single.Release(ctx)
+}
@@ -0,0 +1,105 @@
+package pslock
+
+import (
+	"reflect"
+	"time"
+)
+
+// eventSinkBuffer is the number of events queued for an EventSink before
+// further events are dropped.
+const eventSinkBuffer = 256
+
+// An EventSink receives the lifecycle events of the mutexes created with
+// WithEventSink, e.g. to ship them to an audit pipeline. Emit is called
+// from a single goroutine per sink and PSLock, in the order of the events.
+type EventSink interface {
+	Emit(LockEvent)
+}
+
+// sinkQueue decouples the lock operations from a slow EventSink.
+type sinkQueue struct {
+	events chan LockEvent
+}
+
+// emit queues e unless the queue is full and reports whether it did.
+func (q *sinkQueue) emit(e LockEvent) bool {
+	select {
+	case q.events <- e:
+		return true
+	default:
+		return false
+	}
+}
+
+// sinkKey identifies a sink that cannot be compared, by the option that
+// set it.
+type sinkKey struct {
+	EventSink
+}
+
+// sinkQueue returns the queue of the PSLock for s under key, starting the
+// goroutine that feeds it to s until the PSLock is closed on first use.
+func (r *PSLock) sinkQueue(key any, s EventSink) *sinkQueue {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	q, ok := r.sinks[key]
+	if ok {
+		return q
+	}
+	q = &sinkQueue{events: make(chan LockEvent, eventSinkBuffer)}
+	r.sinks[key] = q
+	go func() {
+		for {
+			select {
+			case e := <-q.events:
+				s.Emit(e)
+			case <-r.closed:
+				return
+			}
+		}
+	}()
+	return q
+}
+
+// emitAll queues e for every event sink used by mutexes of the PSLock.
+func (r *PSLock) emitAll(e LockEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, q := range r.sinks {
+		if !q.emit(e) {
+			defaultLogger.Printf("pslock: event sink is full, dropping %s event of lock %q", e.Event, e.Key)
+		}
+	}
+}
+
+// emit queues an event of the mutex for its event sink, if any, without
+// blocking. A full queue drops the event and logs it.
+func (dl *Mutex) emit(event EventType, token string) {
+	if dl.sinkQueue == nil {
+		return
+	}
+	e := LockEvent{Key: dl.key, Event: event, Holder: dl.name, Time: time.Now(), Token: token}
+	if !dl.sinkQueue.emit(e) {
+		dl.logger.Printf("pslock: event sink is full, dropping %s event of lock %q", event, dl.key)
+	}
+}
+
+// WithEventSink can be used to emit the acquisitions, releases, extensions
+// and detected losses of the lock, and steals by StealLock, to s, e.g. for
+// an audit trail. Each event carries the key, the name of the mutex as the
+// holder, the token and the time. ForceUnlock of the PSLock is emitted to
+// every sink in use. Emission never blocks the lock: events are queued
+// per sink and dropped with a log line while the queue of 256 events is
+// full. A comparable s, e.g. a pointer, is shared by all mutexes using
+// it; any other, e.g. a func, only by the mutexes created with the same
+// option. Events still queued when the PSLock is closed are dropped.
+func WithEventSink(s EventSink) Option {
+	var key any = s
+	if !reflect.ValueOf(s).Comparable() {
+		key = &sinkKey{s}
+	}
+	return OptionFunc(func(m *Mutex) {
+		m.eventSink = s
+		m.eventSinkKey = key
+	})
+}
@@ -0,0 +1,65 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// slogLogger reports problems as warnings on a *slog.Logger.
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Printf(format string, v ...any) {
+	s.l.Warn(fmt.Sprintf(format, v...))
+}
+
+// outcome names the result of a Lock or Unlock for structured logs.
+func outcome(err error, success string) string {
+	switch {
+	case err == nil:
+		return success
+	case errors.Is(err, ErrLockTimeout):
+		return "timeout"
+	case errors.Is(err, ErrLockNotHeld):
+		return "not_held"
+	case errors.Is(err, ErrDraining):
+		return "draining"
+	}
+	return "error"
+}
+
+// logLock logs the result of a Lock that started at start. Acquisitions
+// are logged at debug level and failures as warnings.
+func (dl *Mutex) logLock(ctx context.Context, start time.Time, err error) {
+	level := slog.LevelDebug
+	attrs := []slog.Attr{
+		slog.String("key", dl.key),
+		slog.Int64("tries", dl.attempts.Load()),
+		slog.Duration("elapsed", time.Since(start)),
+		slog.String("outcome", outcome(err, "acquired")),
+	}
+	if err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	dl.slog.LogAttrs(ctx, level, "pslock: lock", attrs...)
+}
+
+// logUnlock logs the result of an Unlock that started at start.
+func (dl *Mutex) logUnlock(ctx context.Context, start time.Time, err error) {
+	level := slog.LevelDebug
+	attrs := []slog.Attr{
+		slog.String("key", dl.key),
+		slog.Duration("elapsed", time.Since(start)),
+		slog.String("outcome", outcome(err, "released")),
+	}
+	if err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	dl.slog.LogAttrs(ctx, level, "pslock: unlock", attrs...)
+}
@@ -0,0 +1,80 @@
+package pslock
+
+import (
+	"context"
+	"hash/fnv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewSpread returns a PSLock that spreads its locks over several
+// independent Redis instances to avoid hot-spotting a single one, e.g.
+// for high-throughput locks that can tolerate the loss of an instance.
+// Each key is placed on an instance chosen by its hash, so that every
+// process agrees on it without coordination; a choice by load would let
+// two processes lock the same key on different instances. All commands,
+// subscriptions and publishes for a key go to its instance, and
+// WithClient still overrides the choice per mutex.
+//
+// This is not Redlock: a lock lives on a single instance only, there is no
+// consensus across instances, and the locks of an instance that fails are
+// lost with it. All processes must pass the same clients in the same
+// order. Like New, it panics if an instance cannot be reached.
+func NewSpread(clients []*redis.Client) *PSLock {
+	if len(clients) == 0 {
+		panic("pslock: NewSpread needs at least one client")
+	}
+	for _, c := range clients[1:] {
+		if err := c.Ping(context.Background()).Err(); err != nil {
+			panic(err)
+		}
+	}
+	r := New(clients[0])
+	r.spread = clients
+	return r
+}
+
+// route places m on the instance of its key, or of its intent parent so
+// that the intent scripts see both keys, unless WithClient chose one.
+func (r *PSLock) route(m *Mutex) {
+	if m.client != nil {
+		return
+	}
+	if m.intentParent != "" {
+		m.client = r.clientFor(m.intentParent)
+		return
+	}
+	m.client = r.clientFor(m.key)
+}
+
+// locate returns the client of the instance holding the lock with key and
+// the key as used in Redis for mutexes created with options, which PSLock
+// methods taking a key use to find the lock: the instance is chosen like
+// NewMutex does, by the plain key, and WithClient and WithKeyEncoding
+// apply.
+func (r *PSLock) locate(key string, options []Option) (*redis.Client, string) {
+	m := &Mutex{key: key}
+	for _, o := range options {
+		o.Apply(m)
+	}
+	r.route(m)
+	return m.client, m.keyEncoding.encode(key)
+}
+
+// clientFor returns the client of the instance holding the lock with key.
+func (r *PSLock) clientFor(key string) *redis.Client {
+	if len(r.spread) == 0 {
+		return r.client
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return r.spread[h.Sum64()%uint64(len(r.spread))]
+}
+
+// clients returns the clients of all instances.
+func (r *PSLock) clients() []*redis.Client {
+	if len(r.spread) == 0 {
+		return []*redis.Client{r.client}
+	}
+	return r.spread
+}
@@ -0,0 +1,106 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// stealablePrefix marks the value of a lock held by a mutex created with
+// WithStealable, followed by the priority of the holder and a colon.
+// Ownership checks compare the whole value, so they are unaffected.
+const stealablePrefix = "steal:"
+
+// errStealablePrefix is returned when a lock value starts with the mark of
+// a stealable lock, which StealLock would take for a stealable holder.
+var errStealablePrefix = errors.New("lock value starts with " + stealablePrefix)
+
+// stealScript takes a lock that is free or held by a stealable holder of
+// lower priority than ARGV[3], overwriting the value of the holder. It
+// returns 1 for a free lock and 2 for a stolen one.
+var stealScript = redis.NewScript(`
+local v = redis.call("GET", KEYS[1])
+if v then
+	local p = string.match(v, "^steal:(%-?%d+):")
+	if not p or tonumber(p) >= tonumber(ARGV[3]) then
+		return 0
+	end
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+if v then
+	return 2
+end
+return 1
+`)
+
+// stealableValue marks value as stealable at the priority of the mutex.
+func (dl *Mutex) stealableValue(value string) string {
+	return stealablePrefix + strconv.Itoa(dl.priority) + ":" + value
+}
+
+// trimStealable returns value without the mark of a stealable lock.
+func trimStealable(value string) string {
+	rest, ok := strings.CutPrefix(value, stealablePrefix)
+	if !ok {
+		return value
+	}
+	if _, after, ok := strings.Cut(rest, ":"); ok {
+		return after
+	}
+	return value
+}
+
+// StealLock acquires the lock without waiting, like TryLock, but also
+// takes it from a holder created with WithStealable whose priority is
+// lower than the one of the mutex given with WithPriority. It reports
+// whether the lock was obtained. The value of the victim is overwritten,
+// so it loses the lock without being told: its renewal watchdog reports
+// the loss through WithOnLost and the context of LockWithRelease, and its
+// Extend and Unlock fail with ErrLockNotHeld. A victim without
+// WithAutoRenew only notices on its next call, so work done under a
+// stealable lock must check for the loss before committing.
+func (dl *Mutex) StealLock(ctx context.Context) (bool, error) {
+	if ctx == nil {
+		return false, fmt.Errorf("%w: %q", ErrNilContext, dl.key)
+	}
+	l, err := dl.newLease(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to steal lock %q: %w", dl.key, err)
+	}
+	n, err := stealScript.Run(ctx, dl.client, []string{dl.getKey()}, l.value, l.expiry.Milliseconds(), dl.priority).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to steal lock %q: %w", dl.key, err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+	dl.acquired(l)
+	if n == 2 {
+		dl.emit(EventStolen, l.value)
+	}
+	return true, nil
+}
+
+// WithStealable can be used to let the locks held by the mutex be taken
+// by StealLock of a mutex with a higher priority, see WithPriority. The
+// priority of the holder is part of the lock value. The default cannot be
+// stolen.
+func WithStealable() Option {
+	return OptionFunc(func(m *Mutex) {
+		m.stealable = true
+	})
+}
+
+// WithPriority can be used to set the priority of the mutex for lock
+// stealing: StealLock only takes locks of stealable holders with a lower
+// priority, and a stealable holder is only robbed by higher priorities.
+// The default is 0.
+func WithPriority(p int) Option {
+	return OptionFunc(func(m *Mutex) {
+		m.priority = p
+	})
+}
@@ -0,0 +1,30 @@
+package pslock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+// tokenBytes is the entropy of a lock token, 128 bits.
+const tokenBytes = 16
+
+// errEmptyToken is returned when a token generator returns an empty token,
+// which would read as a lock that is not held.
+var errEmptyToken = errors.New("token generator returned an empty token")
+
+// genToken returns a random value identifying a single acquisition.
+//
+// Unlock, Extend and the other ownership checks only act on the lock if it
+// still holds this value, so a client that can guess it can forge them.
+// Tokens are therefore drawn from crypto/rand with 128 bits of entropy,
+// which keeps them unguessable even for other tenants of a shared Redis.
+// This does not protect against clients that can read the lock key, and
+// owner IDs set with WithOwnerID are used as is.
+func genToken() (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
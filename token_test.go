@@ -0,0 +1,24 @@
+package pslock
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestGenToken(t *testing.T) {
+	seen := make(map[string]struct{})
+	for range 10000 {
+		token, err := genToken()
+		if err != nil {
+			t.Fatalf("genToken failed: %v", err)
+		}
+		b, err := hex.DecodeString(token)
+		if err != nil || len(b)*8 < 128 {
+			t.Fatalf("expected a hex token of at least 128 bits, got %q", token)
+		}
+		if _, ok := seen[token]; ok {
+			t.Fatalf("expected unique tokens, got %q twice", token)
+		}
+		seen[token] = struct{}{}
+	}
+}
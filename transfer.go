@@ -0,0 +1,52 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// transferScript rewrites the lock value to ARGV[3], keeping its TTL, if
+// the key still holds our value ARGV[1] or the token ARGV[2] replaced by a
+// rotation.
+var transferScript = redis.NewScript(`
+local v = redis.call("GET", KEYS[1])
+if v == ARGV[1] or (ARGV[2] ~= "" and v == ARGV[2]) then
+	redis.call("SET", KEYS[1], ARGV[3], "KEEPTTL")
+	return 1
+end
+return 0
+`)
+
+// Transfer hands the held lock over to newToken without releasing it,
+// e.g. to pass work on to another process. The lease keeps its remaining
+// TTL. It returns false if the lock is not held by the mutex. After a
+// transfer the mutex no longer owns the lock; the new owner can take it
+// over with a mutex created with WithOwnerID(newToken), and waiters are
+// not notified.
+func (dl *Mutex) Transfer(ctx context.Context, newToken string) (bool, error) {
+	if newToken == "" {
+		return false, fmt.Errorf("failed to transfer lock %q: empty token", dl.key)
+	}
+
+	dl.mu.Lock()
+	value, previous := dl.tokensFor(dl.value)
+	dl.mu.Unlock()
+	if value == "" {
+		return false, nil
+	}
+
+	n, err := withOpTimeout(ctx, dl, func(ctx context.Context) (int, error) {
+		return transferScript.Run(ctx, dl.client, []string{dl.getKey()}, value, previous, newToken).Int()
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to transfer lock %q: %w", dl.key, err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+	// The lock belongs to the new owner now
+	dl.released()
+	return true, nil
+}
@@ -0,0 +1,87 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	transientRetryBaseDelay = 20 * time.Millisecond
+	transientRetryMaxDelay  = 500 * time.Millisecond
+)
+
+// transientPrefixes are Redis error prefixes that signal a condition
+// expected to clear up shortly, such as cluster resharding or failover.
+var transientPrefixes = []string{"MOVED ", "ASK ", "TRYAGAIN ", "LOADING ", "CLUSTERDOWN ", "MASTERDOWN ", "READONLY "}
+
+// isTransient reports whether err is a retryable Redis error, as opposed
+// to a permanent failure.
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrOpTimeout) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, prefix := range transientPrefixes {
+		if redis.HasErrorPrefix(err, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayHaveApplied reports whether err leaves open if the command ran, as for
+// an operation given up on after the op timeout or a reply lost with the
+// connection, so that a retried acquisition must accept a lock taken by
+// the attempt that failed. Redis error replies mean the command was
+// refused.
+func mayHaveApplied(err error) bool {
+	if errors.Is(err, ErrOpTimeout) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isPublishRetryable reports whether a failed publish is worth retrying,
+// which is any failure other than the end of the context.
+func isPublishRetryable(err error) bool {
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// withTransientRetries calls op until it succeeds, fails permanently or
+// the configured number of transient retries is used up, backing off
+// exponentially between attempts.
+func (dl *Mutex) withTransientRetries(ctx context.Context, op func() error) error {
+	return withRetries(ctx, dl.transientRetries, isTransient, op)
+}
+
+// withRetries calls op until it succeeds, fails with an error that is
+// not retryable or retries more than n times, backing off exponentially
+// between attempts.
+func withRetries(ctx context.Context, n int, retryable func(error) bool, op func() error) error {
+	delay := transientRetryBaseDelay
+	for i := 0; ; i++ {
+		err := op()
+		if err == nil || i >= n || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, transientRetryMaxDelay)
+	}
+}
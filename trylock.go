@@ -0,0 +1,95 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tryLockHolderScript acquires the lock like acquireScript and returns
+// {1, ""} on success, or {0, value} with the value of the current holder.
+var tryLockHolderScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if ARGV[3] == "1" and current == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return {1, ""}
+end
+if not current then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return {1, ""}
+end
+return {0, current}
+`)
+
+// TryLock acquires the lock if it is free and returns false without
+// waiting otherwise.
+func (dl *Mutex) TryLock(ctx context.Context) (bool, error) {
+	if ctx == nil {
+		return false, fmt.Errorf("%w: %q", ErrNilContext, dl.key)
+	}
+	if dl.pslock.draining.Load() {
+		return false, ErrDraining
+	}
+	l, err := dl.newLease(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+	}
+
+	success, _, err := dl.tryAcquire(ctx, &l)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+	}
+	if success {
+		dl.acquired(l)
+	}
+	return success, nil
+}
+
+// TryLockWithHolder is like TryLock but also returns the value of the
+// current holder if the lock is taken, in the same round trip. The holder
+// is empty if the lock was released in the meantime. Mutexes taking part
+// in intent locking need a second round trip to read the holder.
+func (dl *Mutex) TryLockWithHolder(ctx context.Context) (bool, string, error) {
+	if ctx == nil {
+		return false, "", fmt.Errorf("%w: %q", ErrNilContext, dl.key)
+	}
+	if dl.usesIntents() {
+		if ok, err := dl.TryLock(ctx); ok || err != nil {
+			return ok, "", err
+		}
+		holder, err := dl.client.Get(ctx, dl.getKey()).Result()
+		if err == redis.Nil {
+			return false, "", nil
+		}
+		if err != nil {
+			return false, "", fmt.Errorf("failed to get holder of lock %q: %w", dl.key, err)
+		}
+		return false, holder, nil
+	}
+
+	if dl.pslock.draining.Load() {
+		return false, "", ErrDraining
+	}
+	l, err := dl.newLease(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+	}
+
+	resume := "0"
+	if dl.ownerID != "" {
+		resume = "1"
+	}
+	res, err := withOpTimeout(ctx, dl, func(ctx context.Context) ([]any, error) {
+		return tryLockHolderScript.Run(ctx, dl.client, []string{dl.getKey()}, l.value, l.expiry.Milliseconds(), resume).Slice()
+	})
+	if err != nil {
+		return false, "", fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+	}
+	if n, _ := res[0].(int64); n == 1 {
+		dl.acquired(l)
+		return true, "", nil
+	}
+	holder, _ := res[1].(string)
+	return false, holder, nil
+}
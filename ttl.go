@@ -0,0 +1,58 @@
+package pslock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNoExpiry is returned by TTL when the lock key exists without a TTL.
+var ErrNoExpiry = errors.New("lock has no expiry")
+
+// ttlScript returns the PTTL of the lock if it still holds our value, and
+// -2 like a missing key otherwise. It is mirrored in functionLibrary.
+var ttlScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PTTL", KEYS[1])
+end
+return -2
+`)
+
+// TTL returns the remaining lease of the lock as reported by Redis, less
+// the margin of WithClockSkew. It returns ErrLockNotHeld if the key is
+// gone or held by someone else, and ErrNoExpiry if the key has no TTL.
+func (dl *Mutex) TTL(ctx context.Context) (time.Duration, error) {
+	dl.mu.Lock()
+	value := dl.value
+	dl.mu.Unlock()
+
+	ms, err := withOpTimeout(ctx, dl, func(ctx context.Context) (int64, error) {
+		return dl.runScript(ctx, ttlScript, "pslock_ttl", []string{dl.getKey()}, value).Int64()
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get TTL of lock %q: %w", dl.key, err)
+	}
+	switch ms {
+	case -2:
+		return 0, fmt.Errorf("%w: %q", ErrLockNotHeld, dl.key)
+	case -1:
+		return 0, fmt.Errorf("%w: %q", ErrNoExpiry, dl.key)
+	}
+	return max(time.Duration(ms)*time.Millisecond-dl.clockSkew, 0), nil
+}
+
+// Until returns when the current hold expires as estimated by the local
+// clock from the start of its last acquisition or extension, less the
+// margin of WithClockSkew. The estimate does not call Redis; it is the
+// zero time if the mutex holds no lock or took it with AddToPipe.
+func (dl *Mutex) Until() time.Time {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.until.IsZero() {
+		return time.Time{}
+	}
+	return dl.until.Add(-dl.clockSkew)
+}
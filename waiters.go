@@ -0,0 +1,103 @@
+package pslock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	waitersPrefix = "distributed_lock_waiters:"
+)
+
+// enterWaitersScript counts a waiter and refreshes the counter TTL so that
+// counts of crashed waiters eventually disappear.
+var enterWaitersScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[1])
+return n
+`)
+
+// leaveWaitersScript uncounts a waiter. It never drives the counter below
+// zero, which could happen if the counter expired during a long wait.
+var leaveWaitersScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+local n = redis.call("DECR", KEYS[1])
+if n <= 0 then
+	redis.call("DEL", KEYS[1])
+	return 0
+end
+return n
+`)
+
+func waitersKey(key string) string {
+	return waitersPrefix + key
+}
+
+// auxExpiry returns the TTL of an auxiliary key, def unless WithAuxTTL is
+// given.
+func (dl *Mutex) auxExpiry(def time.Duration) time.Duration {
+	if dl.auxTTL > 0 {
+		return dl.auxTTL
+	}
+	return def
+}
+
+// enterWaiters counts the mutex as a waiter on its key and returns an
+// idempotent func that uncounts it again. Errors are logged only: the
+// count is diagnostic and must not fail an acquisition.
+func (dl *Mutex) enterWaiters(ctx context.Context) func() {
+	key := waitersKey(dl.keyEncoding.encode(dl.key))
+	// Twice the patient covers the whole wait of a live waiter.
+	ttl := dl.auxExpiry(2 * dl.patient)
+	if err := enterWaitersScript.Run(ctx, dl.client, []string{key}, ttl.Milliseconds()).Err(); err != nil {
+		dl.logger.Printf("pslock: failed to count waiter on lock %q: %v", dl.key, err)
+		return func() {}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			// Uncount even if the wait ended because ctx was cancelled.
+			if err := leaveWaitersScript.Run(context.WithoutCancel(ctx), dl.client, []string{key}).Err(); err != nil {
+				dl.logger.Printf("pslock: failed to uncount waiter on lock %q: %v", dl.key, err)
+			}
+		})
+	}
+}
+
+// checkWaiters returns ErrTooManyWaiters if maxWaiters or more mutexes
+// wait for the lock. The check is not atomic with entering the wait, so
+// the limit may be overshot slightly under a burst.
+func (dl *Mutex) checkWaiters(ctx context.Context) error {
+	n, err := withOpTimeout(ctx, dl, func(ctx context.Context) (int64, error) {
+		return dl.client.Get(ctx, waitersKey(dl.keyEncoding.encode(dl.key))).Int64()
+	})
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to acquire lock %q: %w", dl.key, err)
+	}
+	if n >= int64(dl.maxWaiters) {
+		return fmt.Errorf("%w: %q", ErrTooManyWaiters, dl.key)
+	}
+	return nil
+}
+
+// WaiterCount returns how many mutexes are currently waiting in the
+// blocking flow for the lock with given key, across all processes, for
+// mutexes created with options.
+func (r *PSLock) WaiterCount(ctx context.Context, key string, options ...Option) (int64, error) {
+	client, encoded := r.locate(key, options)
+	n, err := client.Get(ctx, waitersKey(encoded)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get waiter count of lock %q: %w", key, err)
+	}
+	return max(n, 0), nil
+}